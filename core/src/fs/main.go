@@ -56,6 +56,20 @@ func ReadFileSerialized(path string, asString bool) []byte {
 }
 
 func WriteFile(path string, data []byte, origin string) error {
+	return writeFile(path, data, 0644, origin)
+}
+
+// perm also applies to an existing file,
+// ignored on the virtual fs
+func WriteFileMode(path string, data []byte, perm os.FileMode, origin string) error {
+	if !WASM {
+		os.Chmod(path, perm)
+	}
+
+	return writeFile(path, data, perm, origin)
+}
+
+func writeFile(path string, data []byte, perm os.FileMode, origin string) error {
 	err := (error)(nil)
 
 	exists, _ := Exists(path)
@@ -63,7 +77,7 @@ func WriteFile(path string, data []byte, origin string) error {
 	if WASM {
 		err = vWriteFile(path, data)
 	} else {
-		err = os.WriteFile(path, data, 0644)
+		err = os.WriteFile(path, data, perm)
 	}
 
 	if !exists {
@@ -169,6 +183,8 @@ func ReadDir(path string, recursive bool, filesOnly bool, skip []string) ([]File
 
 				items = append(items, FileInfo2{
 					Name:  relativeName,
+					Size:  info.Size(),
+					MTime: info.ModTime(),
 					IsDir: d.IsDir(),
 					Mode:  info.Mode(),
 				})
@@ -196,6 +212,8 @@ func ReadDir(path string, recursive bool, filesOnly bool, skip []string) ([]File
 
 				items = append(items, FileInfo2{
 					Name:  item.Name(),
+					Size:  info.Size(),
+					MTime: info.ModTime(),
 					IsDir: item.IsDir(),
 					Mode:  info.Mode(),
 				})
@@ -234,6 +252,24 @@ func ReadDirSerialized(path string, recursive bool, withFileTypes bool, filesOnl
 }
 
 func Mkdir(path string, origin string) bool {
+	return mkdir(path, 0755, origin)
+}
+
+// perm also applies to an existing directory,
+// ignored on the virtual fs
+func MkdirMode(path string, perm os.FileMode, origin string) bool {
+	if !mkdir(path, perm, origin) {
+		return false
+	}
+
+	if !WASM {
+		return os.Chmod(path, perm) == nil
+	}
+
+	return true
+}
+
+func mkdir(path string, perm os.FileMode, origin string) bool {
 	err := (error)(nil)
 
 	exists, _ := Exists(path)
@@ -241,7 +277,7 @@ func Mkdir(path string, origin string) bool {
 	if WASM {
 		err = vMkdir(path)
 	} else {
-		err = os.MkdirAll(path, 0755)
+		err = os.MkdirAll(path, perm)
 	}
 
 	if !exists {
@@ -405,3 +441,46 @@ func Rename(oldPath string, newPath string, origin string) bool {
 func RenameSerialized(oldPath string, newPath string, origin string) []byte {
 	return serialize.SerializeBoolean(Rename(oldPath, newPath, origin))
 }
+
+// hard link, falls back to a copy
+// on virtual fs or across devices
+func Link(oldPath string, newPath string, origin string) error {
+	err := (error)(nil)
+
+	exists, _ := Exists(newPath)
+
+	if WASM {
+		data := ([]byte)(nil)
+		data, err = vReadFile(oldPath)
+		if err == nil {
+			err = vWriteFile(newPath, data)
+		}
+	} else {
+		// never write through a previous link
+		if exists {
+			os.Remove(newPath)
+		}
+
+		err = os.Link(oldPath, newPath)
+		if err != nil {
+			data := ([]byte)(nil)
+			data, err = os.ReadFile(oldPath)
+			if err == nil {
+				err = os.WriteFile(newPath, data, 0644)
+			}
+		}
+	}
+
+	eventType := CREATED
+	if exists {
+		eventType = MODIFIED
+	}
+	watchEvent(FileEvent{
+		Type:   eventType,
+		Paths:  []string{newPath},
+		Origin: origin,
+		IsFile: true,
+	})
+
+	return err
+}
@@ -244,6 +244,8 @@ func vReadDir(path string, recursive bool, filesOnly bool, skip []string) []File
 			if recursive || len(fileComponents)-len(pathComponents) == 1 {
 				items = append(items, FileInfo2{
 					Name:  relativeName,
+					Size:  int64(len(VirtFS[file].Data)),
+					MTime: VirtFS[file].ModTime,
 					IsDir: false,
 					Mode:  0666,
 				})
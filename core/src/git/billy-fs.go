@@ -425,6 +425,17 @@ func (c *content) Truncate() {
 }
 
 func (c *content) Len() int {
+	// never been read,
+	// use size on real fs
+	if c.bytes == nil {
+		stats, err := realFs.Stat(c.path)
+		if err != nil || stats == nil || stats.IsDir {
+			return 0
+		}
+		return int(stats.Size)
+	}
+	// end
+
 	return len(c.bytes)
 }
 
@@ -0,0 +1,55 @@
+package git
+
+import (
+	"strings"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+
+	serialize "fullstackedorg/fullstacked/src/serialize"
+)
+
+// last commit to have modified each line of the file at ref,
+// empty ref blames HEAD
+func Blame(directory string, file string, ref string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	hash, err := resolveRef(repo, ref)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	commit, err := repo.CommitObject(*hash)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	result, err := git.Blame(commit, strings.Trim(file, "/"))
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	wg.Wait()
+
+	data := []byte{}
+
+	// [hash, authorName, authorEmail, date, text, hash, authorName, ...]
+	for _, line := range result.Lines {
+		data = append(data, serialize.SerializeString(line.Hash.String())...)
+		data = append(data, serialize.SerializeString(line.AuthorName)...)
+		data = append(data, serialize.SerializeString(line.Author)...)
+		data = append(data, serialize.SerializeNumber(float64(line.Date.UnixMilli()))...)
+		data = append(data, serialize.SerializeString(line.Text)...)
+	}
+
+	return data
+}
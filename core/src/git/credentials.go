@@ -0,0 +1,386 @@
+package git
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+
+	config "fullstackedorg/fullstacked/src/config"
+	fs "fullstackedorg/fullstacked/src/fs"
+	serialize "fullstackedorg/fullstacked/src/serialize"
+	setup "fullstackedorg/fullstacked/src/setup"
+)
+
+const (
+	credentialsFile    = "git-credentials"
+	credentialsKeyFile = "git-credentials.key"
+	// clear text host credentials saved by the editor
+	legacyCredentialsConfig = "git"
+)
+
+var (
+	ErrCredentialNotFound = errors.New("credential not found")
+	// the stored credentials are lost without their key,
+	// the store must be reset to save new ones
+	ErrCredentialsKeyMissing  = errors.New("credentials key is missing, reset the credentials to continue")
+	ErrCredentialsKeyCorrupt  = errors.New("credentials key is corrupted, reset the credentials to continue")
+	ErrCredentialsKeyMismatch = errors.New("credentials cannot be decrypted with the key, reset the credentials to continue")
+)
+
+// Path scopes the credential to the repositories under it,
+// e.g.: /org for https://host/org/repo.git
+type GitCredential struct {
+	Host     string `json:"host"`
+	Path     string `json:"path"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// sent as bearer token, takes precedence over username/password
+	Token string `json:"token"`
+	// unix ms, 0 never expires
+	Expires int64 `json:"expires"`
+}
+
+func (credential *GitCredential) expired() bool {
+	return credential.Expires > 0 && time.Now().UnixMilli() > credential.Expires
+}
+
+func (credential *GitCredential) matches(host string, repoPath string) bool {
+	if credential.Host != host {
+		return false
+	}
+
+	scope := strings.Trim(credential.Path, "/")
+	repoPath = strings.Trim(repoPath, "/")
+
+	return scope == "" || repoPath == scope || strings.HasPrefix(repoPath, scope+"/")
+}
+
+func (credential *GitCredential) auth() transport.AuthMethod {
+	if credential.Token != "" {
+		return &http.TokenAuth{
+			Token: credential.Token,
+		}
+	}
+
+	return &http.BasicAuth{
+		Username: credential.Username,
+		Password: credential.Password,
+	}
+}
+
+var credentialsMutex = sync.Mutex{}
+
+// generated with the credentials file,
+// readable by the owner only
+func credentialsKey() ([]byte, error) {
+	keyFile := path.Join(setup.Directories.Config, credentialsKeyFile)
+
+	if exists, _ := fs.Exists(keyFile); exists {
+		key, err := fs.ReadFile(keyFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(key) != 32 {
+			return nil, ErrCredentialsKeyCorrupt
+		}
+
+		return key, nil
+	}
+
+	if exists, _ := fs.Exists(path.Join(setup.Directories.Config, credentialsFile)); exists {
+		return nil, ErrCredentialsKeyMissing
+	}
+
+	key := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fs.Mkdir(setup.Directories.Config, fileEventOrigin)
+
+	err = fs.WriteFileMode(keyFile, key, 0600, fileEventOrigin)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func credentialsCipher() (cipher.AEAD, error) {
+	key, err := credentialsKey()
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func readCredentials() ([]GitCredential, error) {
+	credentials := []GitCredential{}
+
+	data, err := fs.ReadFile(path.Join(setup.Directories.Config, credentialsFile))
+
+	if err != nil {
+		return credentials, nil
+	}
+
+	gcm, err := credentialsCipher()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("corrupted credentials file")
+	}
+
+	nonce := data[:gcm.NonceSize()]
+	jsonData, err := gcm.Open(nil, nonce, data[gcm.NonceSize():], nil)
+
+	if err != nil {
+		return nil, ErrCredentialsKeyMismatch
+	}
+
+	err = json.Unmarshal(jsonData, &credentials)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+func writeCredentials(credentials []GitCredential) error {
+	jsonData, err := json.Marshal(credentials)
+
+	if err != nil {
+		return err
+	}
+
+	gcm, err := credentialsCipher()
+
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+
+	if err != nil {
+		return err
+	}
+
+	data := gcm.Seal(nonce, nonce, jsonData, nil)
+
+	return fs.WriteFileMode(path.Join(setup.Directories.Config, credentialsFile), data, 0600, fileEventOrigin)
+}
+
+// replaces the credential with the same host and path
+func setCredential(credentials []GitCredential, credential GitCredential) []GitCredential {
+	credential.Path = strings.Trim(credential.Path, "/")
+
+	for i, c := range credentials {
+		if c.Host == credential.Host && c.Path == credential.Path {
+			credentials[i] = credential
+			return credentials
+		}
+	}
+
+	return append(credentials, credential)
+}
+
+// moves the clear text credentials into the store
+// and removes them from the config directory
+func migrateLegacyCredentials(credentials []GitCredential) ([]GitCredential, bool) {
+	legacyData, err := config.Get(legacyCredentialsConfig)
+
+	if err != nil {
+		return credentials, false
+	}
+
+	legacyConfig := GitAuthConfig{}
+	err = json.Unmarshal(legacyData, &legacyConfig)
+
+	if err != nil {
+		fmt.Println(err)
+		return credentials, false
+	}
+
+	for host, gitAuth := range legacyConfig {
+		credentials = setCredential(credentials, GitCredential{
+			Host:     host,
+			Username: gitAuth.Username,
+			Password: gitAuth.Password,
+		})
+	}
+
+	return credentials, true
+}
+
+func loadCredentials() ([]GitCredential, error) {
+	credentials, err := readCredentials()
+
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, migrated := migrateLegacyCredentials(credentials)
+
+	if migrated {
+		err = writeCredentials(credentials)
+
+		if err != nil {
+			return nil, err
+		}
+
+		fs.Unlink(path.Join(setup.Directories.Config, legacyCredentialsConfig+".json"), fileEventOrigin)
+	}
+
+	return credentials, nil
+}
+
+// the non-expired credential with
+// the longest path matching the url
+func findCredential(gitUrl *url.URL) *GitCredential {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+
+	credentials, err := loadCredentials()
+
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+
+	match := (*GitCredential)(nil)
+
+	for i, c := range credentials {
+		if c.expired() || !c.matches(gitUrl.Host, gitUrl.Path) {
+			continue
+		}
+
+		if match == nil || len(strings.Trim(c.Path, "/")) > len(strings.Trim(match.Path, "/")) {
+			match = &credentials[i]
+		}
+	}
+
+	return match
+}
+
+// secrets are never sent back,
+// [host, path, username, hasToken, expires, ...]
+func CredentialsList() []byte {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+
+	credentials, err := loadCredentials()
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	data := []byte{}
+
+	for _, c := range credentials {
+		data = append(data, serialize.SerializeString(c.Host)...)
+		data = append(data, serialize.SerializeString(c.Path)...)
+		data = append(data, serialize.SerializeString(c.Username)...)
+		data = append(data, serialize.SerializeBoolean(c.Token != "")...)
+		data = append(data, serialize.SerializeNumber(float64(c.Expires))...)
+	}
+
+	return data
+}
+
+func CredentialSave(credential GitCredential) []byte {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+
+	credentials, err := loadCredentials()
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	err = writeCredentials(setCredential(credentials, credential))
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	return nil
+}
+
+func CredentialDelete(host string, credentialPath string) []byte {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+
+	credentials, err := loadCredentials()
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	credentialPath = strings.Trim(credentialPath, "/")
+
+	for i, c := range credentials {
+		if c.Host == host && c.Path == credentialPath {
+			err = writeCredentials(append(credentials[:i], credentials[i+1:]...))
+
+			if err != nil {
+				return serialize.SerializeString(errorFmt(err))
+			}
+
+			return nil
+		}
+	}
+
+	return serialize.SerializeString(errorFmt(ErrCredentialNotFound))
+}
+
+// removes every stored credential and the key,
+// the only way out of a missing or mismatched key
+func CredentialsReset() []byte {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+
+	for _, file := range []string{credentialsFile, credentialsKeyFile} {
+		filePath := path.Join(setup.Directories.Config, file)
+
+		if exists, _ := fs.Exists(filePath); !exists {
+			continue
+		}
+
+		err := fs.Unlink(filePath, fileEventOrigin)
+
+		if err != nil {
+			return serialize.SerializeString(errorFmt(err))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,521 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5/util"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/binary"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+
+	serialize "fullstackedorg/fullstacked/src/serialize"
+)
+
+const diffContextLines = 3
+
+type DiffLineType string
+
+const (
+	DIFF_CONTEXT DiffLineType = " "
+	DIFF_ADD     DiffLineType = "+"
+	DIFF_DELETE  DiffLineType = "-"
+)
+
+// line numbers are 1-based, 0 when the line
+// does not exist on that side
+type DiffLine struct {
+	Type    DiffLineType
+	OldLine int
+	NewLine int
+	Content string
+}
+
+type DiffHunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []DiffLine
+}
+
+// From is empty for added files, To is empty for deleted files
+type FileDiff struct {
+	From   string
+	To     string
+	Binary bool
+	Hunks  []DiffHunk
+	Patch  string
+}
+
+// keeps the line endings so that joining
+// the lines gives back the original content
+func splitLines(content string) []string {
+	if content == "" {
+		return []string{}
+	}
+
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+type lineOp struct {
+	Type  diffmatchpatch.Operation
+	Lines []string
+}
+
+func lineDiff(oldContent string, newContent string) []lineOp {
+	ops := []lineOp{}
+
+	for _, d := range diff.Do(oldContent, newContent) {
+		ops = append(ops, lineOp{
+			Type:  d.Type,
+			Lines: splitLines(d.Text),
+		})
+	}
+
+	return ops
+}
+
+func diffLines(oldContent string, newContent string) []DiffLine {
+	lines := []DiffLine{}
+	oldLine := 1
+	newLine := 1
+
+	for _, op := range lineDiff(oldContent, newContent) {
+		for _, l := range op.Lines {
+			switch op.Type {
+			case diffmatchpatch.DiffEqual:
+				lines = append(lines, DiffLine{DIFF_CONTEXT, oldLine, newLine, l})
+				oldLine++
+				newLine++
+			case diffmatchpatch.DiffDelete:
+				lines = append(lines, DiffLine{DIFF_DELETE, oldLine, 0, l})
+				oldLine++
+			case diffmatchpatch.DiffInsert:
+				lines = append(lines, DiffLine{DIFF_ADD, 0, newLine, l})
+				newLine++
+			}
+		}
+	}
+
+	return lines
+}
+
+func diffHunks(oldContent string, newContent string) []DiffHunk {
+	lines := diffLines(oldContent, newContent)
+	hunks := []DiffHunk{}
+
+	i := 0
+	for i < len(lines) {
+		if lines[i].Type == DIFF_CONTEXT {
+			i++
+			continue
+		}
+
+		start := max(i-diffContextLines, 0)
+
+		// extend while changes are close enough
+		// to share their context lines
+		end := i
+		for j := i; j < len(lines); j++ {
+			if lines[j].Type != DIFF_CONTEXT {
+				end = j
+			} else if j-end > diffContextLines*2 {
+				break
+			}
+		}
+		end = min(end+diffContextLines+1, len(lines))
+
+		hunk := DiffHunk{
+			Lines: lines[start:end],
+		}
+
+		// lines before the hunk on each side
+		oldBefore := 0
+		newBefore := 0
+		for _, l := range lines[:start] {
+			if l.Type != DIFF_ADD {
+				oldBefore++
+			}
+			if l.Type != DIFF_DELETE {
+				newBefore++
+			}
+		}
+
+		for _, l := range hunk.Lines {
+			if l.Type != DIFF_ADD {
+				hunk.OldLines++
+			}
+			if l.Type != DIFF_DELETE {
+				hunk.NewLines++
+			}
+		}
+
+		// an empty side starts at the line before, like git
+		hunk.OldStart = oldBefore
+		if hunk.OldLines > 0 {
+			hunk.OldStart++
+		}
+		hunk.NewStart = newBefore
+		if hunk.NewLines > 0 {
+			hunk.NewStart++
+		}
+
+		hunks = append(hunks, hunk)
+		i = end
+	}
+
+	return hunks
+}
+
+func (fileDiff *FileDiff) patch() string {
+	buf := bytes.Buffer{}
+
+	from := "a/" + fileDiff.From
+	if fileDiff.From == "" {
+		from = "/dev/null"
+	}
+	to := "b/" + fileDiff.To
+	if fileDiff.To == "" {
+		to = "/dev/null"
+	}
+
+	if fileDiff.Binary {
+		fmt.Fprintf(&buf, "Binary files %s and %s differ\n", from, to)
+		return buf.String()
+	}
+
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", from, to)
+
+	for _, hunk := range fileDiff.Hunks {
+		fmt.Fprintf(&buf, "@@ -%d,%d +%d,%d @@\n", hunk.OldStart, hunk.OldLines, hunk.NewStart, hunk.NewLines)
+		for _, l := range hunk.Lines {
+			buf.WriteString(string(l.Type))
+			buf.WriteString(l.Content)
+			if !strings.HasSuffix(l.Content, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+
+	return buf.String()
+}
+
+func isBinary(content []byte) bool {
+	b, _ := binary.IsBinary(bytes.NewReader(content))
+	return b
+}
+
+func newFileDiff(from string, oldContent []byte, to string, newContent []byte) *FileDiff {
+	fileDiff := &FileDiff{
+		From:  from,
+		To:    to,
+		Hunks: []DiffHunk{},
+	}
+
+	if isBinary(oldContent) || isBinary(newContent) {
+		fileDiff.Binary = true
+	} else {
+		fileDiff.Hunks = diffHunks(string(oldContent), string(newContent))
+	}
+
+	fileDiff.Patch = fileDiff.patch()
+
+	return fileDiff
+}
+
+func readFileFromTree(tree *object.Tree, filePath string) ([]byte, bool) {
+	if tree == nil {
+		return nil, false
+	}
+
+	f, err := tree.File(filePath)
+	if err != nil {
+		return nil, false
+	}
+
+	contents, err := f.Contents()
+	if err != nil {
+		return nil, false
+	}
+
+	return []byte(contents), true
+}
+
+func readFileFromIndex(repo *git.Repository, filePath string) ([]byte, bool) {
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return nil, false
+	}
+
+	entry, err := idx.Entry(filePath)
+	if err != nil {
+		return nil, false
+	}
+
+	blob, err := repo.BlobObject(entry.Hash)
+	if err != nil {
+		return nil, false
+	}
+
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, false
+	}
+	defer reader.Close()
+
+	contents := bytes.Buffer{}
+	_, err = contents.ReadFrom(reader)
+	if err != nil {
+		return nil, false
+	}
+
+	return contents.Bytes(), true
+}
+
+func readFileFromWorktree(worktree *git.Worktree, filePath string) ([]byte, bool) {
+	contents, err := util.ReadFile(worktree.Filesystem, filePath)
+	if err != nil {
+		return nil, false
+	}
+
+	return contents, true
+}
+
+// HEAD tree, nil for repositories without commits
+func headTree(repo *git.Repository) (*object.Tree, error) {
+	head, err := repo.Head()
+
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	return commit.Tree()
+}
+
+func sideDiff(filePath string, oldContent []byte, oldExists bool, newContent []byte, newExists bool) *FileDiff {
+	if !oldExists && !newExists {
+		return nil
+	}
+
+	if oldExists && newExists && bytes.Equal(oldContent, newContent) {
+		return nil
+	}
+
+	from := filePath
+	if !oldExists {
+		from = ""
+	}
+	to := filePath
+	if !newExists {
+		to = ""
+	}
+
+	return newFileDiff(from, oldContent, to, newContent)
+}
+
+// staged: index vs HEAD
+// else: worktree vs HEAD
+func Diff(directory string, staged bool, files []string) ([]FileDiff, error) {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		return nil, err
+	}
+
+	wg.Wait()
+
+	status, err := worktreeStatus(repo, worktree)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := headTree(repo)
+
+	if err != nil {
+		return nil, err
+	}
+
+	changed := []string{}
+	for file, fileStatus := range status {
+		if len(files) > 0 && !slices.Contains(files, file) {
+			continue
+		}
+
+		if staged && (fileStatus.Staging == git.Unmodified || fileStatus.Staging == git.Untracked) {
+			continue
+		}
+
+		changed = append(changed, file)
+	}
+	slices.Sort(changed)
+
+	diffs := []FileDiff{}
+
+	for _, file := range changed {
+		oldContent, oldExists := readFileFromTree(tree, file)
+
+		newContent := ([]byte)(nil)
+		newExists := false
+		if staged {
+			newContent, newExists = readFileFromIndex(repo, file)
+		} else {
+			newContent, newExists = readFileFromWorktree(worktree, file)
+		}
+
+		fileDiff := sideDiff(file, oldContent, oldExists, newContent, newExists)
+		if fileDiff != nil {
+			diffs = append(diffs, *fileDiff)
+		}
+	}
+
+	return diffs, nil
+}
+
+func DiffCommits(directory string, fromRef string, toRef string, files []string) ([]FileDiff, error) {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	trees := []*object.Tree{}
+	for _, ref := range []string{fromRef, toRef} {
+		hash, err := resolveRef(repo, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		commit, err := repo.CommitObject(*hash)
+		if err != nil {
+			return nil, err
+		}
+
+		tree, err := commit.Tree()
+		if err != nil {
+			return nil, err
+		}
+
+		trees = append(trees, tree)
+	}
+
+	changes, err := object.DiffTreeWithOptions(context.Background(), trees[0], trees[1], object.DefaultDiffTreeOptions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := []FileDiff{}
+
+	for _, change := range changes {
+		if len(files) > 0 && !slices.Contains(files, change.From.Name) && !slices.Contains(files, change.To.Name) {
+			continue
+		}
+
+		from, to, err := change.Files()
+		if err != nil {
+			return nil, err
+		}
+
+		oldContent := []byte{}
+		if from != nil {
+			contents, err := from.Contents()
+			if err != nil {
+				return nil, err
+			}
+			oldContent = []byte(contents)
+		}
+
+		newContent := []byte{}
+		if to != nil {
+			contents, err := to.Contents()
+			if err != nil {
+				return nil, err
+			}
+			newContent = []byte(contents)
+		}
+
+		diffs = append(diffs, *newFileDiff(change.From.Name, oldContent, change.To.Name, newContent))
+	}
+
+	wg.Wait()
+
+	return diffs, nil
+}
+
+// flat values, each file followed by its hunks
+// and each hunk followed by its lines
+func (fileDiff *FileDiff) serialize() []byte {
+	data := []byte{}
+	data = append(data, serialize.SerializeString(fileDiff.From)...)
+	data = append(data, serialize.SerializeString(fileDiff.To)...)
+	data = append(data, serialize.SerializeBoolean(fileDiff.Binary)...)
+	data = append(data, serialize.SerializeString(fileDiff.Patch)...)
+	data = append(data, serialize.SerializeNumber(float64(len(fileDiff.Hunks)))...)
+	for _, hunk := range fileDiff.Hunks {
+		data = append(data, serialize.SerializeNumber(float64(hunk.OldStart))...)
+		data = append(data, serialize.SerializeNumber(float64(hunk.OldLines))...)
+		data = append(data, serialize.SerializeNumber(float64(hunk.NewStart))...)
+		data = append(data, serialize.SerializeNumber(float64(hunk.NewLines))...)
+		data = append(data, serialize.SerializeNumber(float64(len(hunk.Lines)))...)
+		for _, l := range hunk.Lines {
+			data = append(data, serialize.SerializeString(string(l.Type))...)
+			data = append(data, serialize.SerializeNumber(float64(l.OldLine))...)
+			data = append(data, serialize.SerializeNumber(float64(l.NewLine))...)
+			data = append(data, serialize.SerializeString(l.Content)...)
+		}
+	}
+	return data
+}
+
+func diffsSerialized(diffs []FileDiff, err error) []byte {
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	data := []byte{}
+
+	for _, fileDiff := range diffs {
+		data = append(data, fileDiff.serialize()...)
+	}
+
+	return data
+}
+
+func DiffSerialized(directory string, staged bool, files []string) []byte {
+	return diffsSerialized(Diff(directory, staged, files))
+}
+
+func DiffCommitsSerialized(directory string, fromRef string, toRef string, files []string) []byte {
+	return diffsSerialized(DiffCommits(directory, fromRef, toRef, files))
+}
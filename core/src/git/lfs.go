@@ -0,0 +1,849 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5/util"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	formatConfig "github.com/go-git/go-git/v5/plumbing/format/config"
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+
+	serialize "fullstackedorg/fullstacked/src/serialize"
+)
+
+// go-git has no clean and smudge filters,
+// files tracked with filter=lfs are stored as pointers
+// in the index and replaced by their object in the worktree
+const (
+	lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+	lfsMediaType      = "application/vnd.git-lfs+json"
+	// larger blobs are never pointers
+	lfsPointerMaxSize = 1024
+	lfsObjectsDir     = "lfs/objects"
+)
+
+type lfsPointer struct {
+	// sha256 of the object
+	Oid  string
+	Size int64
+}
+
+func parseLfsPointer(content []byte) (*lfsPointer, bool) {
+	if len(content) > lfsPointerMaxSize || !bytes.HasPrefix(content, []byte(lfsPointerVersion+"\n")) {
+		return nil, false
+	}
+
+	pointer := &lfsPointer{}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		key, value, _ := strings.Cut(line, " ")
+
+		switch key {
+		case "oid":
+			pointer.Oid = strings.TrimPrefix(value, "sha256:")
+		case "size":
+			pointer.Size, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+
+	return pointer, len(pointer.Oid) == sha256.Size*2
+}
+
+func (pointer *lfsPointer) encode() []byte {
+	return []byte(lfsPointerVersion + "\n" +
+		"oid sha256:" + pointer.Oid + "\n" +
+		"size " + strconv.FormatInt(pointer.Size, 10) + "\n")
+}
+
+func newLfsPointer(content []byte) *lfsPointer {
+	sum := sha256.Sum256(content)
+
+	return &lfsPointer{
+		Oid:  hex.EncodeToString(sum[:]),
+		Size: int64(len(content)),
+	}
+}
+
+// files matching filter=lfs in the tracked .gitattributes,
+// nil when the repository does not use lfs
+func lfsMatcher(repo *git.Repository, worktree *git.Worktree) gitattributes.Matcher {
+	idx, err := repo.Storer.Index()
+
+	if err != nil {
+		return nil
+	}
+
+	return lfsIndexMatcher(idx, worktree)
+}
+
+func lfsIndexMatcher(idx *index.Index, worktree *git.Worktree) gitattributes.Matcher {
+	attributes := []gitattributes.MatchAttribute{}
+	usesLfs := false
+
+	for _, entry := range idx.Entries {
+		if path.Base(entry.Name) != ".gitattributes" {
+			continue
+		}
+
+		content, err := util.ReadFile(worktree.Filesystem, entry.Name)
+
+		if err != nil {
+			continue
+		}
+
+		domain := []string{}
+		if dir := path.Dir(entry.Name); dir != "." {
+			domain = strings.Split(dir, "/")
+		}
+
+		fileAttributes, err := gitattributes.ReadAttributes(bytes.NewReader(content), domain, len(domain) == 0)
+
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		for _, a := range fileAttributes {
+			for _, attribute := range a.Attributes {
+				if attribute.Name() == "filter" && attribute.Value() == "lfs" {
+					usesLfs = true
+				}
+			}
+		}
+
+		attributes = append(attributes, fileAttributes...)
+	}
+
+	if !usesLfs {
+		return nil
+	}
+
+	return gitattributes.NewMatcher(attributes)
+}
+
+func lfsTracked(matcher gitattributes.Matcher, file string) bool {
+	if matcher == nil {
+		return false
+	}
+
+	results, _ := matcher.Match(strings.Split(file, "/"), []string{"filter"})
+	filter, ok := results["filter"]
+
+	return ok && filter.IsValueSet() && filter.Value() == "lfs"
+}
+
+// same layout as git-lfs
+func lfsObjectPath(oid string) string {
+	return path.Join(lfsObjectsDir, oid[0:2], oid[2:4], oid)
+}
+
+func lfsObjectExists(repo *git.Repository, pointer *lfsPointer) bool {
+	info, err := dotGitFs(repo).Stat(lfsObjectPath(pointer.Oid))
+	return err == nil && info.Size() == pointer.Size
+}
+
+func readLfsObject(repo *git.Repository, pointer *lfsPointer) ([]byte, error) {
+	return util.ReadFile(dotGitFs(repo), lfsObjectPath(pointer.Oid))
+}
+
+func writeLfsObject(repo *git.Repository, pointer *lfsPointer, content []byte) error {
+	if int64(len(content)) != pointer.Size || newLfsPointer(content).Oid != pointer.Oid {
+		return errors.New("lfs object " + pointer.Oid + " does not match its pointer")
+	}
+
+	return util.WriteFile(dotGitFs(repo), lfsObjectPath(pointer.Oid), content, 0644)
+}
+
+// .lfsconfig lfs.url, else <remote>.git/info/lfs
+func lfsEndpoint(repo *git.Repository, worktree *git.Worktree, remoteName string) (string, error) {
+	lfsConfig, err := util.ReadFile(worktree.Filesystem, ".lfsconfig")
+
+	if err == nil {
+		cfg := formatConfig.New()
+		err = formatConfig.NewDecoder(bytes.NewReader(lfsConfig)).Decode(cfg)
+
+		if err == nil && cfg.Section("lfs").Option("url") != "" {
+			return cfg.Section("lfs").Option("url"), nil
+		}
+	}
+
+	remote, err := resolveRemote(repo, remoteName)
+
+	if err != nil {
+		return "", err
+	}
+
+	url := remote.Config().URLs[0]
+
+	// the lfs api is always reached over https
+	if endpoint := sshEndpoint(url); endpoint != nil {
+		url = "https://" + endpoint.Host + "/" + strings.TrimPrefix(endpoint.Path, "/")
+	}
+
+	url = strings.TrimSuffix(url, "/")
+	if !strings.HasSuffix(url, ".git") {
+		url += ".git"
+	}
+
+	return url + "/info/lfs", nil
+}
+
+type lfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+type lfsObjectError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lfsBatchObject struct {
+	Oid     string               `json:"oid"`
+	Size    int64                `json:"size"`
+	Actions map[string]lfsAction `json:"actions,omitempty"`
+	Error   *lfsObjectError      `json:"error,omitempty"`
+}
+
+type lfsBatchRequest struct {
+	Operation string           `json:"operation"`
+	Transfers []string         `json:"transfers"`
+	Objects   []lfsBatchObject `json:"objects"`
+}
+
+type lfsBatchResponse struct {
+	Objects []lfsBatchObject `json:"objects"`
+}
+
+func lfsRequest(method string, url string, body []byte, header map[string]string, auth bool) (*http.Response, error) {
+	request, err := http.NewRequest(method, url, bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if auth {
+		if httpAuth, ok := checkForGitAuth(url).(githttp.AuthMethod); ok {
+			httpAuth.SetAuth(request)
+		}
+	}
+
+	for key, value := range header {
+		request.Header.Set(key, value)
+	}
+
+	return http.DefaultClient.Do(request)
+}
+
+// operation is download or upload
+func lfsBatch(endpoint string, operation string, pointers []*lfsPointer) ([]lfsBatchObject, error) {
+	batchRequest := lfsBatchRequest{
+		Operation: operation,
+		Transfers: []string{"basic"},
+		Objects:   []lfsBatchObject{},
+	}
+
+	for _, p := range pointers {
+		batchRequest.Objects = append(batchRequest.Objects, lfsBatchObject{
+			Oid:  p.Oid,
+			Size: p.Size,
+		})
+	}
+
+	body, err := json.Marshal(batchRequest)
+
+	if err != nil {
+		return nil, err
+	}
+
+	header := map[string]string{
+		"Accept":       lfsMediaType,
+		"Content-Type": lfsMediaType,
+	}
+
+	url := endpoint + "/objects/batch"
+
+	response, err := lfsRequest(http.MethodPost, url, body, header, true)
+
+	if err == nil && response.StatusCode == http.StatusUnauthorized {
+		response.Body.Close()
+
+		if requestGitAuthentication(url) {
+			response, err = lfsRequest(http.MethodPost, url, body, header, true)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusUnauthorized {
+		return nil, errors.New("authentication required")
+	} else if response.StatusCode >= 300 {
+		return nil, errors.New("lfs batch " + operation + " failed: " + response.Status)
+	}
+
+	batchResponse := lfsBatchResponse{}
+	err = json.NewDecoder(response.Body).Decode(&batchResponse)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range batchResponse.Objects {
+		if o.Error != nil {
+			return nil, errors.New("lfs object " + o.Oid + ": " + o.Error.Message)
+		}
+	}
+
+	return batchResponse.Objects, nil
+}
+
+// actions carry their own authentication in their header
+func lfsTransfer(action lfsAction, method string, body []byte) ([]byte, error) {
+	response, err := lfsRequest(method, action.Href, body, action.Header, false)
+
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return nil, errors.New("lfs transfer failed: " + response.Status)
+	}
+
+	return io.ReadAll(response.Body)
+}
+
+func lfsDownload(repo *git.Repository, endpoint string, pointers []*lfsPointer, progress *GitProgress) error {
+	if len(pointers) == 0 {
+		return nil
+	}
+
+	objects, err := lfsBatch(endpoint, "download", pointers)
+
+	if err != nil {
+		return err
+	}
+
+	for i, o := range objects {
+		action, ok := o.Actions["download"]
+
+		if !ok {
+			return errors.New("lfs object " + o.Oid + " not available")
+		}
+
+		if progress != nil {
+			progress.Write([]byte(fmt.Sprintf("Downloading LFS objects: %d/%d", i+1, len(objects))))
+		}
+
+		content, err := lfsTransfer(action, http.MethodGet, nil)
+
+		if err != nil {
+			return err
+		}
+
+		err = writeLfsObject(repo, &lfsPointer{o.Oid, o.Size}, content)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replaces the pointer files of the worktree by their object,
+// missing objects are downloaded from the remote
+func lfsCheckout(repo *git.Repository, worktree *git.Worktree, remoteName string, progress *GitProgress) error {
+	matcher := lfsMatcher(repo, worktree)
+
+	if matcher == nil {
+		return nil
+	}
+
+	idx, err := repo.Storer.Index()
+
+	if err != nil {
+		return err
+	}
+
+	pointers := map[string]*lfsPointer{}
+	modes := map[string]os.FileMode{}
+	missing := []*lfsPointer{}
+	missingOids := map[string]bool{}
+
+	for _, entry := range idx.Entries {
+		// go-git's index.Merged is 1, merged entries are stage 0
+		if entry.Stage != 0 || !lfsTracked(matcher, entry.Name) {
+			continue
+		}
+
+		info, err := worktree.Filesystem.Lstat(entry.Name)
+
+		if err != nil || info.Size() > lfsPointerMaxSize {
+			continue
+		}
+
+		content, err := util.ReadFile(worktree.Filesystem, entry.Name)
+
+		if err != nil {
+			continue
+		}
+
+		pointer, ok := parseLfsPointer(content)
+
+		if !ok {
+			continue
+		}
+
+		pointers[entry.Name] = pointer
+
+		// keeps the executable bit
+		modes[entry.Name], err = entry.Mode.ToOSFileMode()
+		if err != nil {
+			modes[entry.Name] = 0644
+		}
+
+		if !lfsObjectExists(repo, pointer) && !missingOids[pointer.Oid] {
+			missingOids[pointer.Oid] = true
+			missing = append(missing, pointer)
+		}
+	}
+
+	if len(pointers) == 0 {
+		return nil
+	}
+
+	if len(missing) > 0 {
+		endpoint, err := lfsEndpoint(repo, worktree, remoteName)
+
+		if err != nil {
+			return err
+		}
+
+		err = lfsDownload(repo, endpoint, missing, progress)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for file, pointer := range pointers {
+		content, err := readLfsObject(repo, pointer)
+
+		if err != nil {
+			return err
+		}
+
+		err = util.WriteFile(worktree.Filesystem, file, content, modes[file])
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stores the staged content of lfs tracked files
+// as objects and stages their pointer instead
+func lfsCleanIndex(repo *git.Repository, worktree *git.Worktree) error {
+	matcher := lfsMatcher(repo, worktree)
+
+	if matcher == nil {
+		return nil
+	}
+
+	idx, err := repo.Storer.Index()
+
+	if err != nil {
+		return err
+	}
+
+	cleaned := false
+
+	for _, entry := range idx.Entries {
+		if entry.Stage != 0 || !lfsTracked(matcher, entry.Name) {
+			continue
+		}
+
+		blob, err := repo.BlobObject(entry.Hash)
+
+		if err != nil {
+			return err
+		}
+
+		reader, err := blob.Reader()
+
+		if err != nil {
+			return err
+		}
+
+		content, err := io.ReadAll(reader)
+		reader.Close()
+
+		if err != nil {
+			return err
+		}
+
+		if _, isPointer := parseLfsPointer(content); isPointer {
+			continue
+		}
+
+		pointer := newLfsPointer(content)
+
+		if !lfsObjectExists(repo, pointer) {
+			err = writeLfsObject(repo, pointer, content)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		hash, err := writeBlob(repo, pointer.encode())
+
+		if err != nil {
+			return err
+		}
+
+		entry.Hash = hash
+		cleaned = true
+	}
+
+	if !cleaned {
+		return nil
+	}
+
+	return repo.Storer.SetIndex(idx)
+}
+
+// lfs files replaced by their object are not modified,
+// go-git compares their content with the staged pointer
+func lfsFilterStatus(repo *git.Repository, worktree *git.Worktree, status git.Status) error {
+	matcher := lfsMatcher(repo, worktree)
+
+	if matcher == nil {
+		return nil
+	}
+
+	idx, err := repo.Storer.Index()
+
+	if err != nil {
+		return err
+	}
+
+	for file, fileStatus := range status {
+		if fileStatus.Worktree != git.Modified || !lfsTracked(matcher, file) {
+			continue
+		}
+
+		entry, err := idx.Entry(file)
+
+		if err != nil {
+			continue
+		}
+
+		content, err := util.ReadFile(worktree.Filesystem, file)
+
+		if err != nil {
+			continue
+		}
+
+		pointer := newLfsPointer(content)
+
+		if plumbing.ComputeHash(plumbing.BlobObject, pointer.encode()) != entry.Hash {
+			continue
+		}
+
+		if fileStatus.Staging == git.Unmodified {
+			delete(status, file)
+		} else {
+			fileStatus.Worktree = git.Unmodified
+		}
+	}
+
+	return nil
+}
+
+// worktree status with lfs files compared as pointers
+func worktreeStatus(repo *git.Repository, worktree *git.Worktree) (git.Status, error) {
+	status, err := worktree.Status()
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = lfsFilterStatus(repo, worktree, status)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// commits the push sends, reachable from the local branches,
+// and the tags when pushed, but not from the remote refs
+func pushedCommits(repo *git.Repository, remoteName string, tags bool) ([]*object.Commit, error) {
+	refs, err := repo.References()
+
+	if err != nil {
+		return nil, err
+	}
+
+	local := []plumbing.Hash{}
+	remote := []plumbing.Hash{}
+	remotePrefix := "refs/remotes/" + remoteName + "/"
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		name := ref.Name()
+		if name.IsBranch() || (tags && name.IsTag()) {
+			local = append(local, ref.Hash())
+		} else if strings.HasPrefix(name.String(), remotePrefix) {
+			remote = append(remote, ref.Hash())
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	hashes, err := revlist.Objects(repo.Storer, local, remote)
+
+	if err != nil {
+		return nil, err
+	}
+
+	commits := []*object.Commit{}
+
+	for _, hash := range hashes {
+		obj, err := repo.Storer.EncodedObject(plumbing.AnyObject, hash)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if obj.Type() != plumbing.CommitObject {
+			continue
+		}
+
+		commit, err := object.DecodeCommit(repo.Storer, obj)
+
+		if err != nil {
+			return nil, err
+		}
+
+		commits = append(commits, commit)
+	}
+
+	return commits, nil
+}
+
+// files added or modified by the commit,
+// merges are compared to their first parent,
+// the other parents are pushed commits or already on the remote
+func commitChanges(commit *object.Commit) (object.Changes, error) {
+	tree, err := commit.Tree()
+
+	if err != nil {
+		return nil, err
+	}
+
+	parentTree := (*object.Tree)(nil)
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+
+		if err != nil {
+			return nil, err
+		}
+
+		parentTree, err = parent.Tree()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return object.DiffTree(parentTree, tree)
+}
+
+// uploads the lfs objects of every pushed commit,
+// not only the ones of HEAD
+func lfsPush(repo *git.Repository, worktree *git.Worktree, remoteName string, tags bool, progress *GitProgress) error {
+	matcher := lfsMatcher(repo, worktree)
+
+	if matcher == nil {
+		return nil
+	}
+
+	commits, err := pushedCommits(repo, remoteName, tags)
+
+	if err != nil {
+		return err
+	}
+
+	pointers := []*lfsPointer{}
+	oids := map[string]bool{}
+	// blobs are shared by most commits
+	checked := map[plumbing.Hash]bool{}
+
+	for _, commit := range commits {
+		changes, err := commitChanges(commit)
+
+		if err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			entry := change.To.TreeEntry
+
+			// deleted
+			if change.To.Name == "" || !entry.Mode.IsFile() {
+				continue
+			}
+
+			if checked[entry.Hash] || !lfsTracked(matcher, change.To.Name) {
+				continue
+			}
+			checked[entry.Hash] = true
+
+			blob, err := repo.BlobObject(entry.Hash)
+
+			if err != nil {
+				return err
+			}
+
+			if blob.Size > lfsPointerMaxSize {
+				continue
+			}
+
+			f := object.NewFile(change.To.Name, entry.Mode, blob)
+			content, err := f.Contents()
+
+			if err != nil {
+				return err
+			}
+
+			pointer, ok := parseLfsPointer([]byte(content))
+
+			if !ok || oids[pointer.Oid] || !lfsObjectExists(repo, pointer) {
+				continue
+			}
+
+			oids[pointer.Oid] = true
+			pointers = append(pointers, pointer)
+		}
+	}
+
+	if len(pointers) == 0 {
+		return nil
+	}
+
+	endpoint, err := lfsEndpoint(repo, worktree, remoteName)
+
+	if err != nil {
+		return err
+	}
+
+	objects, err := lfsBatch(endpoint, "upload", pointers)
+
+	if err != nil {
+		return err
+	}
+
+	for i, o := range objects {
+		// no upload action when the remote already has it
+		action, ok := o.Actions["upload"]
+
+		if !ok {
+			continue
+		}
+
+		if progress != nil {
+			progress.Write([]byte(fmt.Sprintf("Uploading LFS objects: %d/%d", i+1, len(objects))))
+		}
+
+		pointer := &lfsPointer{o.Oid, o.Size}
+		content, err := readLfsObject(repo, pointer)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = lfsTransfer(action, http.MethodPut, content)
+
+		if err != nil {
+			return err
+		}
+
+		if verify, ok := o.Actions["verify"]; ok {
+			body, _ := json.Marshal(lfsBatchObject{Oid: o.Oid, Size: o.Size})
+
+			if verify.Header == nil {
+				verify.Header = map[string]string{}
+			}
+			verify.Header["Content-Type"] = lfsMediaType
+
+			_, err = lfsTransfer(verify, http.MethodPost, body)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// downloads the missing lfs objects
+// and replaces the pointer files of the worktree
+func LfsPull(directory string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	err = lfsCheckout(repo, worktree, "", nil)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	wg.Wait()
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package git
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+
+	serialize "fullstackedorg/fullstacked/src/serialize"
+)
+
+// empty ref resolves to HEAD
+func resolveRef(repo *git.Repository, ref string) (*plumbing.Hash, error) {
+	if ref == "" {
+		head, err := repo.Head()
+		if err != nil {
+			return nil, err
+		}
+		hash := head.Hash()
+		return &hash, nil
+	}
+
+	return repo.ResolveRevision(plumbing.Revision(ref))
+}
+
+// matches the file itself or anything under the directory
+func pathFilter(filePath string) func(string) bool {
+	filePath = strings.Trim(filePath, "/")
+
+	return func(p string) bool {
+		return p == filePath || strings.HasPrefix(p, filePath+"/")
+	}
+}
+
+type LogEntry struct {
+	Hash      string
+	Parents   []string
+	Author    object.Signature
+	Committer object.Signature
+	Message   string
+	Signature SignatureStatus
+	// identity of the key that made a good signature
+	Signer string
+}
+
+// keyring is the keys to verify signatures with
+func newLogEntry(commit *object.Commit, keyring openpgp.EntityList) LogEntry {
+	parents := []string{}
+	for _, p := range commit.ParentHashes {
+		parents = append(parents, p.String())
+	}
+
+	signature, signer := verifyCommit(commit, keyring)
+
+	return LogEntry{
+		Hash:      commit.Hash.String(),
+		Parents:   parents,
+		Author:    commit.Author,
+		Committer: commit.Committer,
+		Message:   commit.Message,
+		Signature: signature,
+		Signer:    signer,
+	}
+}
+
+func (entry *LogEntry) serialize() []byte {
+	data := []byte{}
+	data = append(data, serialize.SerializeString(entry.Hash)...)
+	data = append(data, serialize.SerializeString(strings.Join(entry.Parents, " "))...)
+	data = append(data, serialize.SerializeString(entry.Author.Name)...)
+	data = append(data, serialize.SerializeString(entry.Author.Email)...)
+	data = append(data, serialize.SerializeNumber(float64(entry.Author.When.UnixMilli()))...)
+	data = append(data, serialize.SerializeString(entry.Committer.Name)...)
+	data = append(data, serialize.SerializeString(entry.Committer.Email)...)
+	data = append(data, serialize.SerializeNumber(float64(entry.Committer.When.UnixMilli()))...)
+	data = append(data, serialize.SerializeString(entry.Message)...)
+	data = append(data, serialize.SerializeString(string(entry.Signature))...)
+	data = append(data, serialize.SerializeString(entry.Signer)...)
+	return data
+}
+
+// limit <= 0 returns all commits after offset
+func Log(directory string, ref string, limit int, offset int, filePath string) ([]LogEntry, error) {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := resolveRef(repo, ref)
+
+	// no commits yet
+	if err == plumbing.ErrReferenceNotFound && ref == "" {
+		return []LogEntry{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	logOptions := &git.LogOptions{
+		From:  *from,
+		Order: git.LogOrderCommitterTime,
+	}
+
+	if filePath != "" {
+		logOptions.PathFilter = pathFilter(filePath)
+	}
+
+	commits, err := repo.Log(logOptions)
+
+	if err != nil {
+		return nil, err
+	}
+	defer commits.Close()
+
+	entries := []LogEntry{}
+	skipped := 0
+	keyring := trustedKeyring()
+
+	err = commits.ForEach(func(c *object.Commit) error {
+		if skipped < offset {
+			skipped++
+			return nil
+		}
+
+		entries = append(entries, newLogEntry(c, keyring))
+
+		if limit > 0 && len(entries) >= limit {
+			return storer.ErrStop
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	wg.Wait()
+
+	return entries, nil
+}
+
+func LogSerialized(directory string, ref string, limit int, offset int, filePath string) []byte {
+	entries, err := Log(directory, ref, limit, offset, filePath)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	data := []byte{}
+
+	for _, entry := range entries {
+		data = append(data, entry.serialize()...)
+	}
+
+	return data
+}
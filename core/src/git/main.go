@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/url"
 	"path"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -19,10 +21,9 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
 
-	config "fullstackedorg/fullstacked/src/config"
 	fs "fullstackedorg/fullstacked/src/fs"
 	serialize "fullstackedorg/fullstacked/src/serialize"
 	setup "fullstackedorg/fullstacked/src/setup"
@@ -37,10 +38,14 @@ var ignoredDirectories = []string{
 }
 
 type GitMessageJSON struct {
-	Url      string `json:"url"`
-	Data     string `json:"data"`
-	Error    bool   `json:"error"`
-	Finished bool   `json:"finished"`
+	Url       string           `json:"url"`
+	Data      string           `json:"data"`
+	Error     bool             `json:"error"`
+	Finished  bool             `json:"finished"`
+	Conflicts []string         `json:"conflicts,omitempty"`
+	Objects   *GitObjectsCount `json:"objects,omitempty"`
+	// stash entry left with the local changes
+	Stash string `json:"stash,omitempty"`
 }
 
 func errorFmt(e error) string {
@@ -93,20 +98,69 @@ func getWorktree(repo *git.Repository) (*git.Worktree, error) {
 	return worktree, nil
 }
 
+// latest object count reported by the remote,
+// e.g.: Counting objects: 50% (3/6)
+type GitObjectsCount struct {
+	Phase   string `json:"phase"`
+	Current int    `json:"current"`
+	Total   int    `json:"total"`
+}
+
+var (
+	objectsProgressRegex    = regexp.MustCompile(`^(.+ objects):\s+\d+% \((\d+)/(\d+)\)`)
+	objectsEnumeratingRegex = regexp.MustCompile(`^(Enumerating objects): (\d+)`)
+)
+
 type GitProgress struct {
 	ProjectId string
 	Name      string
 	Url       string
+	Conflicts []string
+	Objects   *GitObjectsCount
+	Stash     string
+	// incomplete progress line
+	buffer string
+}
+
+// progress lines are split by \r or \n
+// and can be received in chunks
+func (gitProgress *GitProgress) parseObjectsCount(p []byte) {
+	gitProgress.buffer += string(p)
+
+	lines := strings.FieldsFunc(gitProgress.buffer, func(r rune) bool {
+		return r == '\r' || r == '\n'
+	})
+
+	if !strings.HasSuffix(gitProgress.buffer, "\r") && !strings.HasSuffix(gitProgress.buffer, "\n") && len(lines) > 0 {
+		gitProgress.buffer = lines[len(lines)-1]
+		lines = lines[:len(lines)-1]
+	} else {
+		gitProgress.buffer = ""
+	}
+
+	for _, line := range lines {
+		if match := objectsProgressRegex.FindStringSubmatch(line); match != nil {
+			current, _ := strconv.Atoi(match[2])
+			total, _ := strconv.Atoi(match[3])
+			gitProgress.Objects = &GitObjectsCount{match[1], current, total}
+		} else if match := objectsEnumeratingRegex.FindStringSubmatch(line); match != nil {
+			total, _ := strconv.Atoi(match[2])
+			gitProgress.Objects = &GitObjectsCount{match[1], total, total}
+		}
+	}
 }
 
 func (gitProgress *GitProgress) Write(p []byte) (int, error) {
 	n := len(p)
 
+	gitProgress.parseObjectsCount(p)
+
 	jsonData, _ := json.Marshal(GitMessageJSON{
 		Url:      gitProgress.Url,
 		Data:     strings.TrimSpace(string(p)),
 		Error:    false,
 		Finished: false,
+		Objects:  gitProgress.Objects,
 	})
 
 	setup.Callback(gitProgress.ProjectId, gitProgress.Name, string(jsonData))
@@ -115,10 +169,13 @@ func (gitProgress *GitProgress) Write(p []byte) (int, error) {
 
 func (gitProgress *GitProgress) End(pullResponse string, isError bool) {
 	jsonData, _ := json.Marshal(GitMessageJSON{
-		Url:      gitProgress.Url,
-		Data:     pullResponse,
-		Error:    isError,
-		Finished: true,
+		Url:       gitProgress.Url,
+		Data:      pullResponse,
+		Error:     isError,
+		Finished:  true,
+		Conflicts: gitProgress.Conflicts,
+		Objects:   gitProgress.Objects,
+		Stash:     gitProgress.Stash,
 	})
 
 	setup.Callback(gitProgress.ProjectId, gitProgress.Name, string(jsonData))
@@ -143,42 +200,32 @@ type GitAuth struct {
 
 type GitAuthConfig = map[string]GitAuth
 
-func checkForGitAuth(urlStr string) *http.BasicAuth {
-	gitUrl, err := url.Parse(urlStr)
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
-
-	gitConfig := GitAuthConfig{}
-	gitConfigData, err := config.Get("git")
-
-	if err != nil {
-		return nil
+// ssh urls use the generated key,
+// else the most specific credential saved for the url
+func checkForGitAuth(urlStr string) transport.AuthMethod {
+	if endpoint := sshEndpoint(urlStr); endpoint != nil {
+		return sshAuth(endpoint)
 	}
 
-	err = json.Unmarshal(gitConfigData, &gitConfig)
-
+	gitUrl, err := url.Parse(urlStr)
 	if err != nil {
 		fmt.Println(err)
 		return nil
 	}
 
-	gitAuth, ok := gitConfig[gitUrl.Host]
+	credential := findCredential(gitUrl)
 
-	if !ok {
+	if credential == nil {
 		return nil
 	}
 
-	return &http.BasicAuth{
-		Username: gitAuth.Username,
-		Password: gitAuth.Password,
-	}
+	return credential.auth()
 }
 
 type GitAuthRequest struct {
 	Id        string          `json:"id"`
 	Host      string          `json:"host"`
+	Path      string          `json:"path"`
 	Canceled  bool            `json:"-"`
 	WaitGroup *sync.WaitGroup `json:"-"`
 }
@@ -198,6 +245,7 @@ func requestGitAuthentication(urlStr string) bool {
 	authRequest := GitAuthRequest{
 		Id:        utils.RandString(10),
 		Host:      gitUrl.Host,
+		Path:      gitUrl.Path,
 		WaitGroup: &wg,
 	}
 
@@ -232,7 +280,8 @@ func HasGit(dir string) bool {
 	return exists && !isFile
 }
 
-func RemoteURL(dir string) string {
+// empty remote resolves to the upstream of the current branch
+func RemoteURL(dir string, remoteName string) string {
 	if !HasGit(dir) {
 		return ""
 	}
@@ -245,7 +294,7 @@ func RemoteURL(dir string) string {
 		return ""
 	}
 
-	remote, err := repo.Remote("origin")
+	remote, err := resolveRemote(repo, remoteName)
 
 	if err != nil {
 		return ""
@@ -256,7 +305,60 @@ func RemoteURL(dir string) string {
 	return remote.Config().URLs[0]
 }
 
-func Clone(into string, url string) {
+type CloneOptions struct {
+	// 0 clones the full history
+	Depth        int
+	SingleBranch bool
+	// branch or tag, empty for the remote HEAD
+	Ref string
+}
+
+// resolves a short branch or tag name on the remote
+func remoteRefName(url string, ref string) (plumbing.ReferenceName, error) {
+	if ref == "" {
+		return "", nil
+	}
+
+	if strings.HasPrefix(ref, "refs/") {
+		return plumbing.ReferenceName(ref), nil
+	}
+
+	remote := git.NewRemote(memory.NewStorage(), &gitConfig.RemoteConfig{
+		Name: defaultRemote,
+		URLs: []string{url},
+	})
+
+	refs, err := remote.List(&git.ListOptions{
+		Auth: checkForGitAuth(url),
+	})
+
+	if err != nil && strings.HasPrefix(err.Error(), "authentication required") {
+		if requestGitAuthentication(url) {
+			refs, err = remote.List(&git.ListOptions{
+				Auth: checkForGitAuth(url),
+			})
+		}
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	for _, refName := range []plumbing.ReferenceName{
+		plumbing.NewBranchReferenceName(ref),
+		plumbing.NewTagReferenceName(ref),
+	} {
+		for _, r := range refs {
+			if r.Name() == refName {
+				return refName, nil
+			}
+		}
+	}
+
+	return "", plumbing.ErrReferenceNotFound
+}
+
+func Clone(into string, url string, options CloneOptions) {
 	progress := GitProgress{
 		Name: "git-clone",
 		Url:  url,
@@ -264,6 +366,13 @@ func Clone(into string, url string) {
 
 	wg := sync.WaitGroup{}
 
+	referenceName, err := remoteRefName(url, options.Ref)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
 	dotDir := path.Join(into, ".git")
 	gitFs := (billy.Filesystem)(nil)
 	if fs.WASM {
@@ -278,20 +387,30 @@ func Clone(into string, url string) {
 
 	storage := filesystem.NewStorage(gitFs, cache.NewObjectLRUDefault())
 
-	_, err := git.Clone(filesystem.NewStorage(gitFs, cache.NewObjectLRUDefault()), repoFs, &git.CloneOptions{
-		Auth:     checkForGitAuth(url),
-		URL:      url,
-		Progress: &progress,
-	})
+	cloneOptions := &git.CloneOptions{
+		Auth:          checkForGitAuth(url),
+		URL:           url,
+		Progress:      &progress,
+		Depth:         options.Depth,
+		SingleBranch:  options.SingleBranch,
+		ReferenceName: referenceName,
+	}
+
+	// tags are fetched with the history they point to
+	if options.Depth > 0 || options.SingleBranch {
+		cloneOptions.Tags = git.NoTags
+	}
+
+	repo, err := git.Clone(filesystem.NewStorage(gitFs, cache.NewObjectLRUDefault()), repoFs, cloneOptions)
 
 	if err == transport.ErrEmptyRemoteRepository {
 		fs.Rmdir(into, fileEventOrigin)
 		fs.Mkdir(into, fileEventOrigin)
-		repo, _ := git.InitWithOptions(storage, repoFs, git.InitOptions{
+		repo, _ = git.InitWithOptions(storage, repoFs, git.InitOptions{
 			DefaultBranch: plumbing.Main,
 		})
 		repo.CreateRemote(&gitConfig.RemoteConfig{
-			Name: "origin",
+			Name: defaultRemote,
 			URLs: []string{url},
 		})
 		err = nil
@@ -300,11 +419,8 @@ func Clone(into string, url string) {
 	if err != nil && strings.HasPrefix(err.Error(), "authentication required") {
 		if requestGitAuthentication(url) {
 			fs.Rmdir(into, fileEventOrigin)
-			_, err = git.Clone(filesystem.NewStorage(gitFs, cache.NewObjectLRUDefault()), repoFs, &git.CloneOptions{
-				Auth:     checkForGitAuth(url),
-				URL:      url,
-				Progress: &progress,
-			})
+			cloneOptions.Auth = checkForGitAuth(url)
+			repo, err = git.Clone(filesystem.NewStorage(gitFs, cache.NewObjectLRUDefault()), repoFs, cloneOptions)
 		}
 	}
 
@@ -314,6 +430,33 @@ func Clone(into string, url string) {
 		return
 	}
 
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	// the clone is kept if an lfs object or a submodule fails,
+	// they can be pulled again later
+	err = lfsCheckout(repo, worktree, "", &progress)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	submodulesDepth := 0
+	if options.Depth > 0 {
+		submodulesDepth = 1
+	}
+	err = updateSubmodules(worktree, into, &progress, submodulesDepth)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
 	wg.Wait()
 
 	progress.Write([]byte("done"))
@@ -362,55 +505,26 @@ func Head(directory string) (*plumbing.Reference, error) {
 	return head, nil
 }
 
-func Status(directory string) []byte {
-	wg := sync.WaitGroup{}
-
-	repo, err := getRepo(directory, &wg)
-
-	if err != nil {
-		return serialize.SerializeString(errorFmt(err))
-	}
-
-	worktree, err := getWorktree(repo)
-
-	if err != nil {
-		return serialize.SerializeString(errorFmt(err))
-	}
-
-	err = worktree.AddGlob(".")
-	if err != nil {
-		return serialize.SerializeString(errorFmt(err))
-	}
-
-	wg.Wait()
-
-	status, err := worktree.Status()
-	if err != nil {
-		return serialize.SerializeString(errorFmt(err))
-	}
-
-	data := []byte{}
-
-	// added: 0, deleted: 1, modified: 2,
-	for file, fileStatus := range status {
-		data = append(data, serialize.SerializeString(file)...)
-		switch fileStatus.Staging {
-		case git.Added, git.Copied:
-			// added
-			data = append(data, serialize.SerializeNumber(0)...)
-		case git.Deleted:
-			// deleted
-			data = append(data, serialize.SerializeNumber(1)...)
-		default:
-			// modified
-			data = append(data, serialize.SerializeNumber(2)...)
-		}
+// added: 0, deleted: 1, modified: 2, unmodified: 3, untracked: 4
+func statusCode(code git.StatusCode) int {
+	switch code {
+	case git.Added, git.Copied:
+		return 0
+	case git.Deleted:
+		return 1
+	case git.Unmodified:
+		return 3
+	case git.Untracked:
+		return 4
+	default:
+		return 2
 	}
-
-	return data
 }
 
-func Pull(directory string, isEditor bool, projectId string) {
+// autoStash stashes local changes before pulling
+// and re-applies them once pulled,
+// empty remote pulls from the upstream of the current branch
+func Pull(directory string, isEditor bool, projectId string, authorName string, authorEmail string, autoStash bool, remoteName string) {
 	progress := GitProgress{
 		ProjectId: projectId,
 		Name:      "git-pull",
@@ -432,27 +546,33 @@ func Pull(directory string, isEditor bool, projectId string) {
 		return
 	}
 
-	err = worktree.AddGlob(".")
-	if err != nil {
-		progress.Error(err.Error())
+	wg.Wait()
+
+	if mergeInProgress(repo) {
+		progress.Error(ErrMergeInProgress.Error())
 		return
 	}
 
-	wg.Wait()
+	if sequencerInProgress(repo) {
+		progress.Error(errSequencerInProgress(repo).Error())
+		return
+	}
 
-	status, err := worktree.Status()
+	status, err := worktreeStatus(repo, worktree)
 
 	if err != nil {
 		progress.Error(err.Error())
 		return
 	}
 
-	if !status.IsClean() {
+	hasChanges := !status.IsClean()
+
+	if hasChanges && !autoStash {
 		progress.Error("has changes")
 		return
 	}
 
-	remote, err := repo.Remote("origin")
+	remote, err := resolveRemote(repo, remoteName)
 
 	if err != nil {
 		progress.Error(err.Error())
@@ -463,33 +583,49 @@ func Pull(directory string, isEditor bool, projectId string) {
 
 	progress.Url = remote.Config().URLs[0]
 
-	if !utils.IsReacheable(progress.Url) {
+	if !isReachable(progress.Url) {
 		progress.Error("unreacheable")
 		return
 	}
 
 	progress.Write([]byte("start"))
 
-	err = worktree.AddGlob(".")
+	head, err := repo.Head()
+
 	if err != nil {
 		progress.Error(err.Error())
 		return
 	}
 
-	wg.Wait()
+	// the tracked branch when pulling from the upstream remote,
+	// else the branch with the same name
+	remoteBranch := head.Name().Short()
+	upstreamRemote, upstreamBranch := upstream(repo, remoteBranch)
+	if upstreamRemote == remote.Config().Name {
+		remoteBranch = upstreamBranch
+	}
 
-	head, err := repo.Head()
+	stashed := (*StashEntry)(nil)
+	if hasChanges {
+		stashed, err = stashPush(repo, worktree, "autostash", signature(repo, authorName, authorEmail))
 
-	if err != nil {
-		progress.Error(err.Error())
-		return
+		// only moved submodules
+		if err == ErrNoLocalChanges {
+			err = nil
+		}
+
+		if err != nil {
+			progress.Error(err.Error())
+			return
+		}
 	}
 
 	wg.Wait()
 
 	err = worktree.Pull(&git.PullOptions{
+		RemoteName:    remote.Config().Name,
 		Auth:          checkForGitAuth(progress.Url),
-		ReferenceName: head.Name(),
+		ReferenceName: plumbing.NewBranchReferenceName(remoteBranch),
 		Progress:      &progress,
 	})
 
@@ -498,13 +634,24 @@ func Pull(directory string, isEditor bool, projectId string) {
 	if err != nil && strings.HasPrefix(err.Error(), "authentication required") && isEditor {
 		if requestGitAuthentication(progress.Url) {
 			err = worktree.Pull(&git.PullOptions{
+				RemoteName:    remote.Config().Name,
 				Auth:          checkForGitAuth(progress.Url),
-				ReferenceName: head.Name(),
+				ReferenceName: plumbing.NewBranchReferenceName(remoteBranch),
 				Progress:      &progress,
 			})
 		}
 	}
 
+	// diverged from remote
+	if err == git.ErrNonFastForwardUpdate {
+		author, signatureErr := mergeSignature(repo, authorName, authorEmail)
+		if signatureErr != nil {
+			err = signatureErr
+		} else {
+			progress.Conflicts, err = pullMerge(repo, worktree, remote.Config().Name, remoteBranch, progress.Url, author)
+		}
+	}
+
 	pullResponse := ""
 
 	if err != nil {
@@ -524,10 +671,70 @@ func Pull(directory string, isEditor bool, projectId string) {
 		pullResponse = "already up-to-date"
 	}
 
+	// checkout the lfs objects and the commits recorded for the submodules
+	if len(progress.Conflicts) == 0 && (pullResponse == "" || pullResponse == "already up-to-date") {
+		err = lfsCheckout(repo, worktree, remoteName, &progress)
+
+		if err == nil {
+			err = updateSubmodules(worktree, directory, &progress, 0)
+		}
+
+		if err != nil {
+			pullResponse = err.Error()
+		}
+
+		wg.Wait()
+	}
+
+	if len(progress.Conflicts) > 0 {
+		pullResponse = "merge conflicts"
+	} else if stashed != nil {
+		// the stash is kept if it does not apply cleanly
+		conflicts, stashErr := stashApply(repo, worktree, stashed)
+		if stashErr != nil {
+			pullResponse = stashErr.Error()
+		} else if len(conflicts) > 0 {
+			progress.Conflicts = conflicts
+			pullResponse = "autostash conflicts"
+		} else {
+			stashDrop(repo, stashed.Index)
+			stashed = nil
+		}
+
+		wg.Wait()
+	}
+
+	// the local changes are left in the stash
+	// until the conflicts are resolved
+	if stashed != nil {
+		progress.Stash = "stash@{" + strconv.Itoa(stashed.Index) + "}"
+	}
+
 	progress.End(pullResponse, err != nil)
 }
 
-func Push(directory string) {
+func pullMerge(
+	repo *git.Repository,
+	worktree *git.Worktree,
+	remoteName string,
+	branch string,
+	url string,
+	author *object.Signature,
+) ([]string, error) {
+	remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName(remoteName, branch), true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("Merge branch '%s' of %s", branch, url)
+
+	return merge(repo, worktree, remoteRef.Hash(), remoteName+"/"+branch, message, author)
+}
+
+// tags also pushes all local tags,
+// empty remote pushes to the upstream of the current branch
+func Push(directory string, tags bool, remoteName string) {
 	wg := sync.WaitGroup{}
 
 	progress := GitProgress{
@@ -541,7 +748,7 @@ func Push(directory string) {
 		return
 	}
 
-	remote, err := repo.Remote("origin")
+	remote, err := resolveRemote(repo, remoteName)
 
 	if err != nil {
 		progress.Error(err.Error())
@@ -554,8 +761,30 @@ func Push(directory string) {
 
 	progress.Write([]byte("start"))
 
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	// the remote refuses commits without their lfs objects
+	err = lfsPush(repo, worktree, remote.Config().Name, tags, &progress)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	refSpecs := []gitConfig.RefSpec{}
+	if tags {
+		refSpecs = append(refSpecs, gitConfig.DefaultPushRefSpec, "refs/tags/*:refs/tags/*")
+	}
+
 	err = repo.Push(&git.PushOptions{
-		Auth: checkForGitAuth(progress.Url),
+		RemoteName: remote.Config().Name,
+		Auth:       checkForGitAuth(progress.Url),
+		RefSpecs:   refSpecs,
 		Progress: &GitProgress{
 			Name: "git-push",
 		},
@@ -564,7 +793,9 @@ func Push(directory string) {
 	if err != nil && strings.HasPrefix(err.Error(), "authentication required") {
 		if requestGitAuthentication(progress.Url) {
 			err = repo.Push(&git.PushOptions{
-				Auth: checkForGitAuth(progress.Url),
+				RemoteName: remote.Config().Name,
+				Auth:       checkForGitAuth(progress.Url),
+				RefSpecs:   refSpecs,
 				Progress: &GitProgress{
 					Name: "git-push",
 				},
@@ -612,7 +843,8 @@ func Restore(directory string, files []string) []byte {
 	return nil
 }
 
-func Fetch(directory string) []byte {
+// empty remote fetches the upstream of the current branch
+func Fetch(directory string, remoteName string) []byte {
 	wg := sync.WaitGroup{}
 
 	repo, err := getRepo(directory, &wg)
@@ -621,16 +853,22 @@ func Fetch(directory string) []byte {
 		return serialize.SerializeString(errorFmt(err))
 	}
 
-	remote, _ := repo.Remote("origin")
+	remote, err := resolveRemote(repo, remoteName)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
 
 	err = repo.Fetch(&git.FetchOptions{
-		Auth: checkForGitAuth(remote.Config().URLs[0]),
+		RemoteName: remote.Config().Name,
+		Auth:       checkForGitAuth(remote.Config().URLs[0]),
 	})
 
 	if err != nil && strings.HasPrefix(err.Error(), "authentication required") {
 		if requestGitAuthentication(remote.Config().URLs[0]) {
 			err = repo.Fetch(&git.FetchOptions{
-				Auth: checkForGitAuth(remote.Config().URLs[0]),
+				RemoteName: remote.Config().Name,
+				Auth:       checkForGitAuth(remote.Config().URLs[0]),
 			})
 		}
 	}
@@ -644,7 +882,9 @@ func Fetch(directory string) []byte {
 	return nil
 }
 
-func Commit(directory string, commitMessage string, authorName string, authorEmail string) []byte {
+// stagedOnly commits the index as is,
+// else every change in the worktree is committed
+func Commit(directory string, commitMessage string, authorName string, authorEmail string, stagedOnly bool) []byte {
 	wg := sync.WaitGroup{}
 
 	repo, err := getRepo(directory, &wg)
@@ -653,19 +893,48 @@ func Commit(directory string, commitMessage string, authorName string, authorEma
 		return serialize.SerializeString(errorFmt(err))
 	}
 
+	// the merge is concluded with MergeContinue
+	// to record both parents
+	if mergeInProgress(repo) {
+		return serialize.SerializeString(errorFmt(ErrMergeInProgress))
+	}
+
+	key, err := signKey()
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
 	worktree, err := getWorktree(repo)
 
 	if err != nil {
 		return serialize.SerializeString(errorFmt(err))
 	}
 
+	// staged here rather than with the commit
+	// for the lfs files to be committed as pointers
+	if !stagedOnly {
+		err = worktree.AddWithOptions(&git.AddOptions{All: true})
+		if err != nil {
+			return serialize.SerializeString(errorFmt(err))
+		}
+
+		wg.Wait()
+	}
+
+	err = lfsCleanIndex(repo, worktree)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
 	_, err = worktree.Commit(commitMessage, &git.CommitOptions{
-		All: true,
 		Author: &object.Signature{
 			Name:  authorName,
 			Email: authorEmail,
 			When:  time.Now(),
 		},
+		SignKey: key,
 	})
 
 	if err != nil {
@@ -677,7 +946,7 @@ func Commit(directory string, commitMessage string, authorName string, authorEma
 	return nil
 }
 
-func getRemoteBranches(directory string) ([]plumbing.Reference, error) {
+func getRemoteBranches(directory string, remoteName string) ([]plumbing.Reference, error) {
 	wg := sync.WaitGroup{}
 
 	repo, err := getRepo(directory, &wg)
@@ -686,7 +955,7 @@ func getRemoteBranches(directory string) ([]plumbing.Reference, error) {
 		return nil, err
 	}
 
-	remote, err := repo.Remote("origin")
+	remote, err := resolveRemote(repo, remoteName)
 
 	if err != nil {
 		return nil, err
@@ -724,10 +993,13 @@ type Branch struct {
 	Name   string
 	Local  bool
 	Remote bool
+	// <remote>/<branch>, empty when not tracking
+	Upstream string
 }
 
-func Branches(directory string) []byte {
-	remoteBranches, err := getRemoteBranches(directory)
+// empty remote lists the branches on the upstream of the current branch
+func Branches(directory string, remoteName string) []byte {
+	remoteBranches, err := getRemoteBranches(directory, remoteName)
 
 	if err != nil {
 		return serialize.SerializeString(errorFmt(err))
@@ -774,12 +1046,26 @@ func Branches(directory string) []byte {
 		return nil
 	})
 
+	cfg, err := repo.Config()
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	for i := range branches {
+		b, ok := cfg.Branches[branches[i].Name]
+		if branches[i].Local && ok && b.Remote != "" && b.Merge != "" {
+			branches[i].Upstream = b.Remote + "/" + b.Merge.Short()
+		}
+	}
+
 	branchesSerialized := []byte{}
 
 	for _, b := range branches {
 		branchesSerialized = append(branchesSerialized, serialize.SerializeString(b.Name)...)
 		branchesSerialized = append(branchesSerialized, serialize.SerializeBoolean(b.Remote)...)
 		branchesSerialized = append(branchesSerialized, serialize.SerializeBoolean(b.Local)...)
+		branchesSerialized = append(branchesSerialized, serialize.SerializeString(b.Upstream)...)
 	}
 
 	return branchesSerialized
@@ -851,7 +1137,7 @@ func findRefType(directory string, ref string) RefType {
 
 	wg.Wait()
 
-	remoteBranches, err := getRemoteBranches(directory)
+	remoteBranches, err := getRemoteBranches(directory, "")
 	if err == nil {
 		for _, b := range remoteBranches {
 			if b.Name().Short() == ref {
@@ -907,6 +1193,7 @@ func CheckoutRef(
 	}
 
 	worktree.AddGlob(".")
+	lfsCleanIndex(repo, worktree)
 	wg.Wait()
 
 	switch refType {
@@ -942,6 +1229,10 @@ func CheckoutRef(
 		}
 	}
 
+	if err == nil {
+		err = lfsCheckout(repo, worktree, "", nil)
+	}
+
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -980,7 +1271,7 @@ func Checkout(
 		return nil
 	})
 
-	remoteBranches, err := getRemoteBranches(directory)
+	remoteBranches, err := getRemoteBranches(directory, "")
 
 	if err != nil {
 		return serialize.SerializeString(errorFmt(err))
@@ -997,7 +1288,7 @@ func Checkout(
 	}
 
 	if refOnRemote {
-		remote, err := repo.Remote("origin")
+		remote, err := resolveRemote(repo, "")
 
 		if err != nil {
 			return serialize.SerializeString(errorFmt(err))
@@ -1043,6 +1334,7 @@ func Checkout(
 	// changing branch with unstaged changes
 	if !create {
 		worktree.AddGlob(".")
+		lfsCleanIndex(repo, worktree)
 		wg.Wait()
 	}
 
@@ -1055,6 +1347,12 @@ func Checkout(
 		return serialize.SerializeString(errorFmt(err))
 	}
 
+	err = lfsCheckout(repo, worktree, "", nil)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
 	wg.Wait()
 
 	return nil
@@ -0,0 +1,665 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	git "github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/sergi/go-diff/diffmatchpatch"
+
+	serialize "fullstackedorg/fullstacked/src/serialize"
+)
+
+const (
+	mergeHeadFile = "MERGE_HEAD"
+	mergeMsgFile  = "MERGE_MSG"
+)
+
+var ErrMergeInProgress = errors.New("merge in progress")
+var ErrNoMergeInProgress = errors.New("no merge in progress")
+
+// a change of base lines [BaseStart, BaseEnd) into Lines
+type mergeHunk struct {
+	BaseStart int
+	BaseEnd   int
+	Lines     []string
+	Theirs    bool
+}
+
+func mergeHunks(base string, side string, theirs bool) []mergeHunk {
+	hunks := []mergeHunk{}
+	basePos := 0
+	current := (*mergeHunk)(nil)
+
+	for _, op := range lineDiff(base, side) {
+		if op.Type == diffmatchpatch.DiffEqual {
+			if current != nil {
+				hunks = append(hunks, *current)
+				current = nil
+			}
+			basePos += len(op.Lines)
+			continue
+		}
+
+		if current == nil {
+			current = &mergeHunk{
+				BaseStart: basePos,
+				BaseEnd:   basePos,
+				Lines:     []string{},
+				Theirs:    theirs,
+			}
+		}
+
+		if op.Type == diffmatchpatch.DiffDelete {
+			basePos += len(op.Lines)
+			current.BaseEnd = basePos
+		} else {
+			current.Lines = append(current.Lines, op.Lines...)
+		}
+	}
+
+	if current != nil {
+		hunks = append(hunks, *current)
+	}
+
+	return hunks
+}
+
+// the content of base lines [start, end) with hunks applied
+func applyHunks(baseLines []string, start int, end int, hunks []mergeHunk) []string {
+	lines := []string{}
+	pos := start
+
+	for _, h := range hunks {
+		lines = append(lines, baseLines[pos:h.BaseStart]...)
+		lines = append(lines, h.Lines...)
+		pos = h.BaseEnd
+	}
+
+	return append(lines, baseLines[pos:end]...)
+}
+
+func writeMergeLines(buf *bytes.Buffer, lines []string) {
+	for _, l := range lines {
+		buf.WriteString(l)
+	}
+
+	// markers must start on their own line
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		buf.WriteString("\n")
+	}
+}
+
+// line based three-way merge,
+// conflicting regions are wrapped in conflict markers
+func mergeFileContents(base string, ours string, theirs string, oursLabel string, theirsLabel string) (string, bool) {
+	if ours == theirs || base == theirs {
+		return ours, false
+	}
+
+	if base == ours {
+		return theirs, false
+	}
+
+	baseLines := splitLines(base)
+
+	hunks := append(mergeHunks(base, ours, false), mergeHunks(base, theirs, true)...)
+	slices.SortStableFunc(hunks, func(a, b mergeHunk) int {
+		return a.BaseStart - b.BaseStart
+	})
+
+	buf := bytes.Buffer{}
+	conflict := false
+	basePos := 0
+
+	i := 0
+	for i < len(hunks) {
+		groupStart := hunks[i].BaseStart
+		groupEnd := hunks[i].BaseEnd
+		group := []mergeHunk{hunks[i]}
+		i++
+
+		// overlapping or touching hunks are merged together
+		for i < len(hunks) && hunks[i].BaseStart <= groupEnd {
+			groupEnd = max(groupEnd, hunks[i].BaseEnd)
+			group = append(group, hunks[i])
+			i++
+		}
+
+		buf.WriteString(strings.Join(baseLines[basePos:groupStart], ""))
+		basePos = groupEnd
+
+		oursHunks := []mergeHunk{}
+		theirsHunks := []mergeHunk{}
+		for _, h := range group {
+			if h.Theirs {
+				theirsHunks = append(theirsHunks, h)
+			} else {
+				oursHunks = append(oursHunks, h)
+			}
+		}
+
+		oursLines := applyHunks(baseLines, groupStart, groupEnd, oursHunks)
+		theirsLines := applyHunks(baseLines, groupStart, groupEnd, theirsHunks)
+
+		if len(theirsHunks) == 0 || slices.Equal(oursLines, theirsLines) {
+			buf.WriteString(strings.Join(oursLines, ""))
+			continue
+		} else if len(oursHunks) == 0 {
+			buf.WriteString(strings.Join(theirsLines, ""))
+			continue
+		}
+
+		conflict = true
+		buf.WriteString("<<<<<<< " + oursLabel + "\n")
+		writeMergeLines(&buf, oursLines)
+		buf.WriteString("=======\n")
+		writeMergeLines(&buf, theirsLines)
+		buf.WriteString(">>>>>>> " + theirsLabel + "\n")
+	}
+
+	buf.WriteString(strings.Join(baseLines[basePos:], ""))
+
+	return buf.String(), conflict
+}
+
+func treeFiles(tree *object.Tree) (map[string]*object.File, error) {
+	files := map[string]*object.File{}
+
+	if tree == nil {
+		return files, nil
+	}
+
+	err := tree.Files().ForEach(func(f *object.File) error {
+		files[f.Name] = f
+		return nil
+	})
+
+	return files, err
+}
+
+func sameFile(a *object.File, b *object.File) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	return a.Hash == b.Hash && a.Mode == b.Mode
+}
+
+func fileContents(f *object.File) (string, bool) {
+	if f == nil {
+		return "", false
+	}
+
+	isBin, err := f.IsBinary()
+	if err != nil || isBin {
+		return "", true
+	}
+
+	contents, err := f.Contents()
+	if err != nil {
+		return "", true
+	}
+
+	return contents, false
+}
+
+func osFileMode(f *object.File) os.FileMode {
+	mode, err := f.Mode.ToOSFileMode()
+	if err != nil {
+		return 0644
+	}
+	return mode
+}
+
+func setIndexEntry(idx *index.Index, name string, hash plumbing.Hash, f *object.File) {
+	entry, err := idx.Entry(name)
+	if err != nil {
+		entry = idx.Add(name)
+	}
+
+	*entry = index.Entry{
+		Name: name,
+		Hash: hash,
+		Mode: f.Mode,
+	}
+}
+
+// applies the changes from base to theirs on top of ours
+// into the worktree and the index, returns the conflicted files
+func mergeTrees(
+	repo *git.Repository,
+	worktree *git.Worktree,
+	base *object.Tree,
+	ours *object.Tree,
+	theirs *object.Tree,
+	oursLabel string,
+	theirsLabel string,
+) ([]string, error) {
+	baseFiles, err := treeFiles(base)
+	if err != nil {
+		return nil, err
+	}
+	oursFiles, err := treeFiles(ours)
+	if err != nil {
+		return nil, err
+	}
+	theirsFiles, err := treeFiles(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	paths := []string{}
+	for _, files := range []map[string]*object.File{baseFiles, oursFiles, theirsFiles} {
+		for name := range files {
+			if !seen[name] {
+				seen[name] = true
+				paths = append(paths, name)
+			}
+		}
+	}
+	slices.Sort(paths)
+
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts := []string{}
+
+	for _, name := range paths {
+		b := baseFiles[name]
+		o := oursFiles[name]
+		t := theirsFiles[name]
+
+		if sameFile(o, t) || sameFile(b, t) {
+			continue
+		}
+
+		// only theirs changed
+		if sameFile(b, o) {
+			if t == nil {
+				worktree.Filesystem.Remove(name)
+				idx.Remove(name)
+				continue
+			}
+
+			contents, err := t.Contents()
+			if err != nil {
+				return nil, err
+			}
+
+			err = util.WriteFile(worktree.Filesystem, name, []byte(contents), osFileMode(t))
+			if err != nil {
+				return nil, err
+			}
+
+			setIndexEntry(idx, name, t.Hash, t)
+			continue
+		}
+
+		// modified on one side, deleted on the other
+		if o == nil || t == nil {
+			if o == nil {
+				contents, err := t.Contents()
+				if err != nil {
+					return nil, err
+				}
+
+				err = util.WriteFile(worktree.Filesystem, name, []byte(contents), osFileMode(t))
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			conflicts = append(conflicts, name)
+			continue
+		}
+
+		baseContents, baseBinary := fileContents(b)
+		oursContents, oursBinary := fileContents(o)
+		theirsContents, theirsBinary := fileContents(t)
+
+		// keep ours
+		if baseBinary || oursBinary || theirsBinary {
+			conflicts = append(conflicts, name)
+			continue
+		}
+
+		merged, conflict := mergeFileContents(baseContents, oursContents, theirsContents, oursLabel, theirsLabel)
+
+		err = util.WriteFile(worktree.Filesystem, name, []byte(merged), osFileMode(o))
+		if err != nil {
+			return nil, err
+		}
+
+		if conflict {
+			conflicts = append(conflicts, name)
+			continue
+		}
+
+		hash, err := writeBlob(repo, []byte(merged))
+		if err != nil {
+			return nil, err
+		}
+
+		setIndexEntry(idx, name, hash, o)
+	}
+
+	err = repo.Storer.SetIndex(idx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return conflicts, nil
+}
+
+func dotGitFs(repo *git.Repository) billy.Filesystem {
+	return repo.Storer.(*filesystem.Storage).Filesystem()
+}
+
+func mergeInProgress(repo *git.Repository) bool {
+	_, err := dotGitFs(repo).Stat(mergeHeadFile)
+	return err == nil
+}
+
+// like git, conflicts are listed as comments in MERGE_MSG
+func writeMergeState(repo *git.Repository, theirs plumbing.Hash, message string, conflicts []string) error {
+	gitFs := dotGitFs(repo)
+
+	err := util.WriteFile(gitFs, mergeHeadFile, []byte(theirs.String()+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+
+	message = strings.TrimSpace(message) + "\n\n# Conflicts:\n"
+	for _, c := range conflicts {
+		message += "#\t" + c + "\n"
+	}
+
+	return util.WriteFile(gitFs, mergeMsgFile, []byte(message), 0644)
+}
+
+func readMergeState(repo *git.Repository) (plumbing.Hash, string, []string, error) {
+	gitFs := dotGitFs(repo)
+
+	mergeHead, err := util.ReadFile(gitFs, mergeHeadFile)
+	if err != nil {
+		return plumbing.ZeroHash, "", nil, ErrNoMergeInProgress
+	}
+
+	mergeMsg, _ := util.ReadFile(gitFs, mergeMsgFile)
+
+	messageLines := []string{}
+	conflicts := []string{}
+	for _, line := range strings.Split(string(mergeMsg), "\n") {
+		if strings.HasPrefix(line, "#\t") {
+			conflicts = append(conflicts, strings.TrimPrefix(line, "#\t"))
+		} else if !strings.HasPrefix(line, "#") {
+			messageLines = append(messageLines, line)
+		}
+	}
+
+	hash := plumbing.NewHash(strings.TrimSpace(string(mergeHead)))
+	message := strings.TrimSpace(strings.Join(messageLines, "\n"))
+
+	return hash, message, conflicts, nil
+}
+
+func clearMergeState(repo *git.Repository) {
+	gitFs := dotGitFs(repo)
+	gitFs.Remove(mergeHeadFile)
+	gitFs.Remove(mergeMsgFile)
+}
+
+var ErrMissingAuthor = errors.New("author name and email are required")
+
+// falls back on the repository config
+// when no name and email are given
+func mergeSignature(repo *git.Repository, name string, email string) (*object.Signature, error) {
+	if name == "" && email == "" {
+		cfg, err := repo.ConfigScoped(gitConfig.SystemScope)
+		if err == nil {
+			name = cfg.User.Name
+			email = cfg.User.Email
+		}
+	}
+
+	if name == "" || email == "" {
+		return nil, ErrMissingAuthor
+	}
+
+	return &object.Signature{
+		Name:  name,
+		Email: email,
+		When:  time.Now(),
+	}, nil
+}
+
+// merges theirs into HEAD, commits when there is no conflicts
+func merge(
+	repo *git.Repository,
+	worktree *git.Worktree,
+	theirsHash plumbing.Hash,
+	theirsLabel string,
+	message string,
+	author *object.Signature,
+) ([]string, error) {
+	// before touching the worktree
+	key, err := signKey()
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	ours, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	theirs, err := repo.CommitObject(theirsHash)
+	if err != nil {
+		return nil, err
+	}
+
+	bases, err := ours.MergeBase(theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bases) == 0 {
+		return nil, errors.New("refusing to merge unrelated histories")
+	}
+
+	baseTree, err := bases[0].Tree()
+	if err != nil {
+		return nil, err
+	}
+	oursTree, err := ours.Tree()
+	if err != nil {
+		return nil, err
+	}
+	theirsTree, err := theirs.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts, err := mergeTrees(repo, worktree, baseTree, oursTree, theirsTree, "HEAD", theirsLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(conflicts) > 0 {
+		return conflicts, writeMergeState(repo, theirsHash, message, conflicts)
+	}
+
+	_, err = worktree.Commit(message, &git.CommitOptions{
+		Author:            author,
+		Parents:           []plumbing.Hash{ours.Hash, theirs.Hash},
+		AllowEmptyCommits: true,
+		SignKey:           key,
+	})
+
+	return nil, err
+}
+
+func hasConflictMarkers(content []byte) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "<<<<<<< ") || strings.HasPrefix(line, ">>>>>>> ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func MergeAbort(directory string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	_, _, conflicts, err := readMergeState(repo)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	head, err := repo.Head()
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	err = worktree.Reset(&git.ResetOptions{
+		Commit: head.Hash(),
+		Mode:   git.HardReset,
+	})
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	// conflicted files coming from theirs only
+	// are untracked and survive the reset
+	tree, err := headTree(repo)
+	if err == nil && tree != nil {
+		for _, c := range conflicts {
+			if _, err := tree.File(c); err != nil {
+				worktree.Filesystem.Remove(c)
+			}
+		}
+	}
+
+	clearMergeState(repo)
+
+	wg.Wait()
+
+	return nil
+}
+
+// stages the conflicted files and commits the merge,
+// fails if conflict markers are left
+func MergeContinue(directory string, authorName string, authorEmail string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	theirsHash, message, conflicts, err := readMergeState(repo)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	author, err := mergeSignature(repo, authorName, authorEmail)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	key, err := signKey()
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	unresolved := []string{}
+	for _, c := range conflicts {
+		contents, err := util.ReadFile(worktree.Filesystem, c)
+		if err == nil && hasConflictMarkers(contents) {
+			unresolved = append(unresolved, c)
+		}
+	}
+
+	if len(unresolved) > 0 {
+		return serialize.SerializeString(errorFmt(errors.New("unresolved conflicts: " + strings.Join(unresolved, ", "))))
+	}
+
+	for _, c := range conflicts {
+		_, err = worktree.Add(c)
+
+		if err != nil {
+			return serialize.SerializeString(errorFmt(err))
+		}
+	}
+
+	head, err := repo.Head()
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	_, err = worktree.Commit(message, &git.CommitOptions{
+		Author:            author,
+		Parents:           []plumbing.Hash{head.Hash(), theirsHash},
+		AllowEmptyCommits: true,
+		SignKey:           key,
+	})
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	clearMergeState(repo)
+
+	wg.Wait()
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package git
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+type treeEntry struct {
+	Hash plumbing.Hash
+	Mode filemode.FileMode
+}
+
+func writeBlob(repo *git.Repository, data []byte) (plumbing.Hash, error) {
+	obj := repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(int64(len(data)))
+
+	writer, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	_, err = writer.Write(data)
+	writer.Close()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return repo.Storer.SetEncodedObject(obj)
+}
+
+// files are keyed by their full path,
+// sub trees are written recursively
+func writeTree(repo *git.Repository, files map[string]treeEntry) (plumbing.Hash, error) {
+	entries := []object.TreeEntry{}
+	subTrees := map[string]map[string]treeEntry{}
+
+	for name, f := range files {
+		dir, rest, isNested := strings.Cut(name, "/")
+
+		if !isNested {
+			entries = append(entries, object.TreeEntry{
+				Name: name,
+				Mode: f.Mode,
+				Hash: f.Hash,
+			})
+			continue
+		}
+
+		if subTrees[dir] == nil {
+			subTrees[dir] = map[string]treeEntry{}
+		}
+		subTrees[dir][rest] = f
+	}
+
+	for dir, subFiles := range subTrees {
+		hash, err := writeTree(repo, subFiles)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		entries = append(entries, object.TreeEntry{
+			Name: dir,
+			Mode: filemode.Dir,
+			Hash: hash,
+		})
+	}
+
+	sort.Sort(object.TreeEntrySorter(entries))
+
+	tree := &object.Tree{
+		Entries: entries,
+	}
+
+	obj := repo.Storer.NewEncodedObject()
+	err := tree.Encode(obj)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return repo.Storer.SetEncodedObject(obj)
+}
+
+func writeCommit(repo *git.Repository, commit *object.Commit) (plumbing.Hash, error) {
+	obj := repo.Storer.NewEncodedObject()
+	err := commit.Encode(obj)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return repo.Storer.SetEncodedObject(obj)
+}
+
+// for objects written without go-git's commit options,
+// falls back on the repository config
+func signature(repo *git.Repository, name string, email string) object.Signature {
+	if name == "" && email == "" {
+		cfg, err := repo.ConfigScoped(gitConfig.SystemScope)
+		if err == nil {
+			name = cfg.User.Name
+			email = cfg.User.Email
+		}
+	}
+
+	if name == "" {
+		name = "FullStacked"
+	}
+
+	return object.Signature{
+		Name:  name,
+		Email: email,
+		When:  time.Now(),
+	}
+}
@@ -0,0 +1,315 @@
+package git
+
+import (
+	"strings"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	serialize "fullstackedorg/fullstacked/src/serialize"
+)
+
+const defaultRemote = "origin"
+
+// remote and branch a local branch tracks,
+// falls back to the same branch on origin
+func upstream(repo *git.Repository, branch string) (string, string) {
+	cfg, err := repo.Config()
+
+	if err == nil {
+		if b, ok := cfg.Branches[branch]; ok && b.Remote != "" && b.Merge != "" {
+			return b.Remote, b.Merge.Short()
+		}
+	}
+
+	return defaultRemote, branch
+}
+
+// empty remote name resolves to the upstream of the current branch
+func resolveRemote(repo *git.Repository, remoteName string) (*git.Remote, error) {
+	if remoteName == "" {
+		remoteName = defaultRemote
+
+		head, err := repo.Head()
+		if err == nil && head.Name().IsBranch() {
+			remoteName, _ = upstream(repo, head.Name().Short())
+		}
+	}
+
+	return repo.Remote(remoteName)
+}
+
+type Remote struct {
+	Name string
+	Url  string
+}
+
+func Remotes(directory string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	remotes, err := repo.Remotes()
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	wg.Wait()
+
+	remotesSerialized := []byte{}
+
+	for _, r := range remotes {
+		remote := Remote{
+			Name: r.Config().Name,
+		}
+
+		if len(r.Config().URLs) > 0 {
+			remote.Url = r.Config().URLs[0]
+		}
+
+		remotesSerialized = append(remotesSerialized, serialize.SerializeString(remote.Name)...)
+		remotesSerialized = append(remotesSerialized, serialize.SerializeString(remote.Url)...)
+	}
+
+	return remotesSerialized
+}
+
+func RemoteAdd(directory string, name string, url string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	_, err = repo.CreateRemote(&gitConfig.RemoteConfig{
+		Name: name,
+		URLs: []string{url},
+	})
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+func removeRemoteRefs(repo *git.Repository, name string) ([]*plumbing.Reference, error) {
+	refs, err := repo.References()
+
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := "refs/remotes/" + name + "/"
+	removed := []*plumbing.Reference{}
+
+	err = refs.ForEach(func(r *plumbing.Reference) error {
+		if strings.HasPrefix(r.Name().String(), prefix) {
+			removed = append(removed, r)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range removed {
+		err = repo.Storer.RemoveReference(r.Name())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return removed, nil
+}
+
+// also removes the remote-tracking branches
+// and the upstream of branches tracking it
+func RemoteRemove(directory string, name string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	err = repo.DeleteRemote(name)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	_, err = removeRemoteRefs(repo, name)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	cfg, err := repo.Config()
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	for _, b := range cfg.Branches {
+		if b.Remote == name {
+			b.Remote = ""
+			b.Merge = ""
+		}
+	}
+
+	err = repo.SetConfig(cfg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+// moves the remote-tracking branches and
+// updates the branches tracking the remote
+func RemoteRename(directory string, name string, newName string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	cfg, err := repo.Config()
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	remote, ok := cfg.Remotes[name]
+
+	if !ok {
+		return serialize.SerializeString(errorFmt(git.ErrRemoteNotFound))
+	}
+
+	if _, exists := cfg.Remotes[newName]; exists {
+		return serialize.SerializeString(errorFmt(git.ErrRemoteExists))
+	}
+
+	fetch := []gitConfig.RefSpec{}
+	for _, refSpec := range remote.Fetch {
+		fetch = append(fetch, gitConfig.RefSpec(strings.ReplaceAll(
+			refSpec.String(),
+			"refs/remotes/"+name+"/",
+			"refs/remotes/"+newName+"/",
+		)))
+	}
+
+	delete(cfg.Remotes, name)
+	cfg.Remotes[newName] = &gitConfig.RemoteConfig{
+		Name:  newName,
+		URLs:  remote.URLs,
+		Fetch: fetch,
+	}
+
+	for _, b := range cfg.Branches {
+		if b.Remote == name {
+			b.Remote = newName
+		}
+	}
+
+	err = repo.SetConfig(cfg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	refs, err := removeRemoteRefs(repo, name)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	for _, r := range refs {
+		refName := plumbing.NewRemoteReferenceName(newName, strings.TrimPrefix(r.Name().String(), "refs/remotes/"+name+"/"))
+
+		if r.Type() == plumbing.SymbolicReference {
+			target := plumbing.NewRemoteReferenceName(newName, strings.TrimPrefix(r.Target().String(), "refs/remotes/"+name+"/"))
+			err = repo.Storer.SetReference(plumbing.NewSymbolicReference(refName, target))
+		} else {
+			err = repo.Storer.SetReference(plumbing.NewHashReference(refName, r.Hash()))
+		}
+
+		if err != nil {
+			return serialize.SerializeString(errorFmt(err))
+		}
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+// empty remote removes the upstream of the branch
+func SetUpstream(directory string, branch string, remote string, remoteBranch string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	cfg, err := repo.Config()
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	b, ok := cfg.Branches[branch]
+	if !ok {
+		b = &gitConfig.Branch{
+			Name: branch,
+		}
+		cfg.Branches[branch] = b
+	}
+
+	if remote == "" {
+		b.Remote = ""
+		b.Merge = ""
+	} else {
+		if _, ok := cfg.Remotes[remote]; !ok {
+			return serialize.SerializeString(errorFmt(git.ErrRemoteNotFound))
+		}
+
+		if remoteBranch == "" {
+			remoteBranch = branch
+		}
+
+		b.Remote = remote
+		b.Merge = plumbing.NewBranchReferenceName(remoteBranch)
+	}
+
+	err = repo.SetConfig(cfg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	wg.Wait()
+
+	return nil
+}
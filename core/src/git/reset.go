@@ -0,0 +1,89 @@
+package git
+
+import (
+	"errors"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+
+	serialize "fullstackedorg/fullstacked/src/serialize"
+)
+
+type ResetMode string
+
+const (
+	// moves HEAD only
+	RESET_SOFT ResetMode = "soft"
+	// moves HEAD and resets the index, keeps the worktree
+	RESET_MIXED ResetMode = "mixed"
+	// moves HEAD, resets the index and the worktree,
+	// untracked files are kept
+	RESET_HARD ResetMode = "hard"
+)
+
+var ErrInvalidResetMode = errors.New("invalid reset mode")
+
+// moves the current branch to ref.
+// a hard reset discards an ongoing merge, a soft one is refused.
+// cherry-pick, rebase and revert are kept, like git does,
+// to split a commit while stopped
+func Reset(directory string, ref string, mode ResetMode) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	resetMode := git.MixedReset
+	switch mode {
+	case RESET_SOFT:
+		resetMode = git.SoftReset
+	case RESET_MIXED, "":
+		resetMode = git.MixedReset
+	case RESET_HARD:
+		resetMode = git.HardReset
+	default:
+		return serialize.SerializeString(errorFmt(ErrInvalidResetMode))
+	}
+
+	if resetMode == git.SoftReset && mergeInProgress(repo) {
+		return serialize.SerializeString(errorFmt(ErrMergeInProgress))
+	}
+
+	hash, err := resolveRef(repo, ref)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	err = worktree.Reset(&git.ResetOptions{
+		Commit: *hash,
+		Mode:   resetMode,
+	})
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	if resetMode == git.HardReset {
+		err = lfsCheckout(repo, worktree, "", nil)
+
+		if err != nil {
+			return serialize.SerializeString(errorFmt(err))
+		}
+
+		clearMergeState(repo)
+	}
+
+	wg.Wait()
+
+	return nil
+}
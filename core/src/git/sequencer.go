@@ -0,0 +1,717 @@
+package git
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5/util"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	serialize "fullstackedorg/fullstacked/src/serialize"
+)
+
+// cherry-pick, rebase and revert apply a list of commits one by one,
+// stopping on conflicts until continued or aborted
+const sequencerDir = "sequencer"
+
+type SequencerOperation string
+
+const (
+	SEQUENCER_CHERRY_PICK SequencerOperation = "cherry-pick"
+	SEQUENCER_REBASE      SequencerOperation = "rebase"
+	SEQUENCER_REVERT      SequencerOperation = "revert"
+)
+
+var ErrNoSequencerInProgress = errors.New("no cherry-pick, rebase or revert in progress")
+var ErrMergeCommit = errors.New("merge commits cannot be applied")
+
+type sequencerState struct {
+	Operation SequencerOperation
+	// commits left to apply,
+	// the first one is the one stopped on
+	Todo []plumbing.Hash
+	// HEAD before the operation, restored on abort
+	OrigHead plumbing.Hash
+	// branch being rebased, empty for cherry-pick
+	HeadName  plumbing.ReferenceName
+	Conflicts []string
+}
+
+func sequencerInProgress(repo *git.Repository) bool {
+	_, err := dotGitFs(repo).Stat(path.Join(sequencerDir, "operation"))
+	return err == nil
+}
+
+func errSequencerInProgress(repo *git.Repository) error {
+	state, err := readSequencerState(repo)
+	if err != nil {
+		return err
+	}
+
+	return errors.New(string(state.Operation) + " in progress")
+}
+
+func writeSequencerState(repo *git.Repository, state *sequencerState) error {
+	gitFs := dotGitFs(repo)
+
+	action := "pick "
+	if state.Operation == SEQUENCER_REVERT {
+		action = "revert "
+	}
+
+	todo := ""
+	for _, hash := range state.Todo {
+		todo += action + hash.String() + "\n"
+	}
+
+	files := map[string]string{
+		"operation": string(state.Operation),
+		"todo":      todo,
+		"head":      state.OrigHead.String(),
+		"head-name": state.HeadName.String(),
+		"conflicts": strings.Join(state.Conflicts, "\n"),
+	}
+
+	for name, contents := range files {
+		err := util.WriteFile(gitFs, path.Join(sequencerDir, name), []byte(contents+"\n"), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readSequencerFile(repo *git.Repository, name string) string {
+	contents, _ := util.ReadFile(dotGitFs(repo), path.Join(sequencerDir, name))
+	return strings.TrimSpace(string(contents))
+}
+
+func readSequencerState(repo *git.Repository) (*sequencerState, error) {
+	if !sequencerInProgress(repo) {
+		return nil, ErrNoSequencerInProgress
+	}
+
+	state := &sequencerState{
+		Operation: SequencerOperation(readSequencerFile(repo, "operation")),
+		Todo:      []plumbing.Hash{},
+		OrigHead:  plumbing.NewHash(readSequencerFile(repo, "head")),
+		HeadName:  plumbing.ReferenceName(readSequencerFile(repo, "head-name")),
+		Conflicts: []string{},
+	}
+
+	for _, line := range strings.Split(readSequencerFile(repo, "todo"), "\n") {
+		_, hash, ok := strings.Cut(line, " ")
+		if ok {
+			state.Todo = append(state.Todo, plumbing.NewHash(hash))
+		}
+	}
+
+	for _, line := range strings.Split(readSequencerFile(repo, "conflicts"), "\n") {
+		if line != "" {
+			state.Conflicts = append(state.Conflicts, line)
+		}
+	}
+
+	return state, nil
+}
+
+func clearSequencerState(repo *git.Repository) {
+	util.RemoveAll(dotGitFs(repo), sequencerDir)
+}
+
+// merges the changes introduced by the commit into HEAD,
+// or their inverse when reverting, returns the conflicted files
+func applyCommit(repo *git.Repository, worktree *git.Worktree, operation SequencerOperation, commit *object.Commit) ([]string, error) {
+	if commit.NumParents() > 1 {
+		return nil, ErrMergeCommit
+	}
+
+	parentTree := (*object.Tree)(nil)
+	if commit.NumParents() == 1 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+
+		parentTree, err = parent.Tree()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	oursTree, err := headTree(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	commitTree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	if operation == SEQUENCER_REVERT {
+		return mergeTrees(repo, worktree, commitTree, oursTree, parentTree, "HEAD", "parent of "+commitLabel(commit))
+	}
+
+	return mergeTrees(repo, worktree, parentTree, oursTree, commitTree, "HEAD", commitLabel(commit))
+}
+
+func commitLabel(commit *object.Commit) string {
+	subject, _, _ := strings.Cut(commit.Message, "\n")
+	return commit.Hash.String()[:7] + " (" + subject + ")"
+}
+
+func revertMessage(commit *object.Commit) string {
+	subject, _, _ := strings.Cut(commit.Message, "\n")
+	return "Revert \"" + subject + "\"\n\nThis reverts commit " + commit.Hash.String() + ".\n"
+}
+
+// picks keep the original author and message,
+// changes already applied are skipped
+func commitApplied(worktree *git.Worktree, operation SequencerOperation, commit *object.Commit, committer *object.Signature) error {
+	message := commit.Message
+	author := &commit.Author
+
+	if operation == SEQUENCER_REVERT {
+		message = revertMessage(commit)
+		author = committer
+	}
+
+	key, err := signKey()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = worktree.Commit(message, &git.CommitOptions{
+		Author:    author,
+		Committer: committer,
+		SignKey:   key,
+	})
+
+	if err == git.ErrEmptyCommit {
+		return nil
+	}
+
+	return err
+}
+
+// applies the todo list, saves the state
+// and returns the conflicts when stopping
+func runSequencer(
+	repo *git.Repository,
+	worktree *git.Worktree,
+	state *sequencerState,
+	committer *object.Signature,
+	progress *GitProgress,
+) ([]string, error) {
+	for len(state.Todo) > 0 {
+		commit, err := repo.CommitObject(state.Todo[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if state.Operation == SEQUENCER_REVERT {
+			progress.Write([]byte("Reverting " + commitLabel(commit)))
+		} else {
+			progress.Write([]byte("Applying " + commitLabel(commit)))
+		}
+
+		conflicts, err := applyCommit(repo, worktree, state.Operation, commit)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(conflicts) > 0 {
+			state.Conflicts = conflicts
+			return conflicts, writeSequencerState(repo, state)
+		}
+
+		err = commitApplied(worktree, state.Operation, commit, committer)
+		if err != nil {
+			return nil, err
+		}
+
+		state.Todo = state.Todo[1:]
+		state.Conflicts = []string{}
+
+		err = writeSequencerState(repo, state)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// rebased branch moves to the detached HEAD
+	if state.HeadName != "" {
+		head, err := repo.Head()
+		if err != nil {
+			return nil, err
+		}
+
+		err = repo.Storer.SetReference(plumbing.NewHashReference(state.HeadName, head.Hash()))
+		if err != nil {
+			return nil, err
+		}
+
+		err = repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, state.HeadName))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	clearSequencerState(repo)
+
+	return nil, nil
+}
+
+// operations rewriting history need a clean worktree,
+// no other operation in progress and a usable signing key
+func checkSequencerStart(repo *git.Repository, worktree *git.Worktree) error {
+	if mergeInProgress(repo) {
+		return ErrMergeInProgress
+	}
+
+	if _, err := signKey(); err != nil {
+		return err
+	}
+
+	if sequencerInProgress(repo) {
+		return errSequencerInProgress(repo)
+	}
+
+	status, err := worktreeStatus(repo, worktree)
+	if err != nil {
+		return err
+	}
+
+	if !status.IsClean() {
+		return errors.New("has changes")
+	}
+
+	return nil
+}
+
+func endSequencer(progress *GitProgress, conflicts []string, err error) {
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	if len(conflicts) > 0 {
+		progress.Conflicts = conflicts
+		progress.End("conflicts", false)
+		return
+	}
+
+	progress.End("", false)
+}
+
+// applies the commits on top of HEAD in the given order
+func CherryPick(directory string, projectId string, hashes []string, committerName string, committerEmail string) {
+	applyCommits(directory, projectId, SEQUENCER_CHERRY_PICK, hashes, committerName, committerEmail)
+}
+
+// commits the inverse of the changes introduced by the commit
+func Revert(directory string, projectId string, hash string, committerName string, committerEmail string) {
+	applyCommits(directory, projectId, SEQUENCER_REVERT, []string{hash}, committerName, committerEmail)
+}
+
+func applyCommits(
+	directory string,
+	projectId string,
+	operation SequencerOperation,
+	hashes []string,
+	committerName string,
+	committerEmail string,
+) {
+	progress := GitProgress{
+		ProjectId: projectId,
+		Name:      "git-" + string(operation),
+	}
+
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	wg.Wait()
+
+	err = checkSequencerStart(repo, worktree)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	head, err := repo.Head()
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	state := &sequencerState{
+		Operation: operation,
+		Todo:      []plumbing.Hash{},
+		OrigHead:  head.Hash(),
+	}
+
+	for _, h := range hashes {
+		hash, err := resolveRef(repo, h)
+
+		if err != nil {
+			progress.Error(err.Error())
+			return
+		}
+
+		state.Todo = append(state.Todo, *hash)
+	}
+
+	err = writeSequencerState(repo, state)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	committer := signature(repo, committerName, committerEmail)
+	conflicts, err := runSequencer(repo, worktree, state, &committer, &progress)
+
+	wg.Wait()
+
+	endSequencer(&progress, conflicts, err)
+}
+
+// commits on the first-parent history of the current branch
+// not reachable from onto, oldest first, merges are dropped
+func rebaseTodo(head *object.Commit, onto *object.Commit) ([]plumbing.Hash, error) {
+	upstream := map[plumbing.Hash]bool{}
+
+	err := object.NewCommitPreorderIter(onto, nil, nil).ForEach(func(c *object.Commit) error {
+		upstream[c.Hash] = true
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	todo := []plumbing.Hash{}
+	commit := head
+
+	for !upstream[commit.Hash] {
+		if commit.NumParents() <= 1 {
+			todo = append([]plumbing.Hash{commit.Hash}, todo...)
+		}
+
+		if commit.NumParents() == 0 {
+			break
+		}
+
+		commit, err = commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return todo, nil
+}
+
+// replays the commits of the current branch on top of onto
+func Rebase(directory string, projectId string, onto string, committerName string, committerEmail string) {
+	progress := GitProgress{
+		ProjectId: projectId,
+		Name:      "git-rebase",
+	}
+
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	wg.Wait()
+
+	err = checkSequencerStart(repo, worktree)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	head, err := repo.Head()
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	headCommit, err := repo.CommitObject(head.Hash())
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	ontoHash, err := resolveRef(repo, onto)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	ontoCommit, err := repo.CommitObject(*ontoHash)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	upToDate, err := ontoCommit.IsAncestor(headCommit)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	if upToDate || ontoCommit.Hash == headCommit.Hash {
+		progress.End("up-to-date", false)
+		return
+	}
+
+	todo, err := rebaseTodo(headCommit, ontoCommit)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	state := &sequencerState{
+		Operation: SEQUENCER_REBASE,
+		Todo:      todo,
+		OrigHead:  head.Hash(),
+	}
+
+	if head.Name().IsBranch() {
+		state.HeadName = head.Name()
+	}
+
+	err = writeSequencerState(repo, state)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	progress.Write([]byte("Rebasing onto " + ontoCommit.Hash.String()[:7]))
+
+	// commits are applied on a detached HEAD
+	err = repo.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, *ontoHash))
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	err = worktree.Reset(&git.ResetOptions{
+		Commit: *ontoHash,
+		Mode:   git.HardReset,
+	})
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	committer := signature(repo, committerName, committerEmail)
+	conflicts, err := runSequencer(repo, worktree, state, &committer, &progress)
+
+	wg.Wait()
+
+	endSequencer(&progress, conflicts, err)
+}
+
+// the operation is unknown until the state is read,
+// early errors are sent to every sequencer listener
+func sequencerError(progress *GitProgress, err error) {
+	for _, operation := range []SequencerOperation{SEQUENCER_CHERRY_PICK, SEQUENCER_REBASE, SEQUENCER_REVERT} {
+		progress.Name = "git-" + string(operation)
+		progress.Error(err.Error())
+	}
+}
+
+// commits the resolved conflicts and applies
+// the remaining commits, fails if conflict markers are left
+func SequencerContinue(directory string, projectId string, committerName string, committerEmail string) {
+	progress := GitProgress{
+		ProjectId: projectId,
+	}
+
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		sequencerError(&progress, err)
+		return
+	}
+
+	wg.Wait()
+
+	// finished with ErrNoSequencerInProgress
+	// when there is nothing to continue
+	state, err := readSequencerState(repo)
+
+	if err != nil {
+		sequencerError(&progress, err)
+		return
+	}
+
+	progress.Name = "git-" + string(state.Operation)
+
+	_, err = signKey()
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		progress.Error(err.Error())
+		return
+	}
+
+	unresolved := []string{}
+	for _, c := range state.Conflicts {
+		contents, err := util.ReadFile(worktree.Filesystem, c)
+		if err == nil && hasConflictMarkers(contents) {
+			unresolved = append(unresolved, c)
+		}
+	}
+
+	if len(unresolved) > 0 {
+		progress.Error("unresolved conflicts: " + strings.Join(unresolved, ", "))
+		return
+	}
+
+	for _, c := range state.Conflicts {
+		_, err = worktree.Add(c)
+
+		if err != nil {
+			progress.Error(err.Error())
+			return
+		}
+	}
+
+	committer := signature(repo, committerName, committerEmail)
+
+	if len(state.Todo) > 0 {
+		commit, err := repo.CommitObject(state.Todo[0])
+
+		if err != nil {
+			progress.Error(err.Error())
+			return
+		}
+
+		err = commitApplied(worktree, state.Operation, commit, &committer)
+
+		if err != nil {
+			progress.Error(err.Error())
+			return
+		}
+
+		state.Todo = state.Todo[1:]
+		state.Conflicts = []string{}
+	}
+
+	conflicts, err := runSequencer(repo, worktree, state, &committer, &progress)
+
+	wg.Wait()
+
+	endSequencer(&progress, conflicts, err)
+}
+
+// restores HEAD and the worktree as before the operation
+func SequencerAbort(directory string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	state, err := readSequencerState(repo)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	if state.HeadName != "" {
+		err = repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, state.HeadName))
+
+		if err != nil {
+			return serialize.SerializeString(errorFmt(err))
+		}
+	}
+
+	err = worktree.Reset(&git.ResetOptions{
+		Commit: state.OrigHead,
+		Mode:   git.HardReset,
+	})
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	// conflicted files coming from the picked commit
+	// are untracked and survive the reset
+	tree, err := headTree(repo)
+	if err == nil && tree != nil {
+		for _, c := range state.Conflicts {
+			if _, err := tree.File(c); err != nil {
+				worktree.Filesystem.Remove(c)
+			}
+		}
+	}
+
+	clearSequencerState(repo)
+
+	wg.Wait()
+
+	return nil
+}
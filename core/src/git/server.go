@@ -0,0 +1,526 @@
+package git
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+
+	fs "fullstackedorg/fullstacked/src/fs"
+	serialize "fullstackedorg/fullstacked/src/serialize"
+	setup "fullstackedorg/fullstacked/src/setup"
+)
+
+// serves the projects under the root directory
+// with the git smart HTTP protocol, e.g.:
+// git clone http://<address>:<port>/<project>
+type GitServer struct {
+	Port int
+	// basic auth password, any username is accepted
+	Password string
+	// refuses pushes
+	ReadOnly bool
+	// listens on every interface instead of loopback only,
+	// traffic is not encrypted
+	Exposed bool
+	server  *http.Server
+}
+
+var ErrServerRunning = errors.New("git server already running")
+var ErrServerNotRunning = errors.New("git server not running")
+var ErrServerUnavailable = errors.New("git server is not available in the browser")
+
+var activeServer *GitServer = nil
+var activeServerMutex = sync.Mutex{}
+
+const (
+	uploadPackService  = "git-upload-pack"
+	receivePackService = "git-receive-pack"
+
+	noThinCapability capability.Capability = "no-thin"
+)
+
+// a project is a repository directly under the root directory
+func serverProjectDirectory(project string) (string, bool) {
+	project = strings.TrimSuffix(strings.Trim(project, "/"), ".git")
+
+	if project == "" || strings.Contains(project, "/") || project == "." || project == ".." {
+		return "", false
+	}
+
+	directory := path.Join(setup.Directories.Root, project)
+
+	return directory, HasGit(directory)
+}
+
+type projectsLoader struct{}
+
+// the endpoint path is the project directory
+func (projectsLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(ep.Path, &wg)
+
+	if err != nil {
+		return nil, transport.ErrRepositoryNotFound
+	}
+
+	wg.Wait()
+
+	return repo.Storer, nil
+}
+
+var gitTransportServer = server.NewServer(projectsLoader{})
+
+func (gitServer *GitServer) authorized(r *http.Request) bool {
+	_, password, ok := r.BasicAuth()
+	return ok && subtle.ConstantTimeCompare([]byte(password), []byte(gitServer.Password)) == 1
+}
+
+func requestBody(r *http.Request) (io.ReadCloser, error) {
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		return gzip.NewReader(r.Body)
+	}
+
+	return r.Body, nil
+}
+
+func (gitServer *GitServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !gitServer.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="FullStacked"`)
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	project, service, isInfoRefs := strings.Cut(r.URL.Path, "/info/refs")
+
+	if isInfoRefs {
+		service = r.URL.Query().Get("service")
+	} else {
+		project, service = path.Split(r.URL.Path)
+	}
+
+	if service != uploadPackService && service != receivePackService {
+		http.Error(w, "service not supported", http.StatusForbidden)
+		return
+	}
+
+	if service == receivePackService && gitServer.ReadOnly {
+		http.Error(w, "read-only", http.StatusForbidden)
+		return
+	}
+
+	directory, ok := serverProjectDirectory(project)
+
+	if !ok {
+		http.Error(w, transport.ErrRepositoryNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	endpoint, err := transport.NewEndpoint(directory)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if isInfoRefs {
+		err = advertiseReferences(w, r, endpoint, service)
+	} else if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	} else if service == uploadPackService {
+		err = uploadPack(w, r, endpoint)
+	} else {
+		err = receivePack(w, r, endpoint, directory)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func advertiseReferences(w http.ResponseWriter, r *http.Request, endpoint *transport.Endpoint, service string) error {
+	var session transport.Session = nil
+	err := (error)(nil)
+
+	if service == uploadPackService {
+		session, err = gitTransportServer.NewUploadPackSession(endpoint, nil)
+	} else {
+		session, err = gitTransportServer.NewReceivePackSession(endpoint, nil)
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	defer session.Close()
+
+	advRefs, err := session.AdvertisedReferencesContext(r.Context())
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	// go-git cannot resolve the deltas of thin packs
+	// against objects already stored
+	if service == receivePackService {
+		err = advRefs.Capabilities.Add(noThinCapability)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+	}
+
+	advRefs.Prefix = [][]byte{
+		[]byte("# service=" + service),
+		pktline.Flush,
+	}
+
+	w.Header().Set("Content-Type", "application/x-"+service+"-advertisement")
+
+	return advRefs.Encode(w)
+}
+
+func uploadPack(w http.ResponseWriter, r *http.Request, endpoint *transport.Endpoint) error {
+	body, err := requestBody(r)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	defer body.Close()
+
+	request := packp.NewUploadPackRequest()
+	err = request.Decode(body)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	session, err := gitTransportServer.NewUploadPackSession(endpoint, nil)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	defer session.Close()
+
+	response, err := session.UploadPack(r.Context(), request)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	defer response.Close()
+
+	w.Header().Set("Content-Type", "application/x-"+uploadPackService+"-result")
+
+	return response.Encode(w)
+}
+
+// like git with receive.denyCurrentBranch=updateInstead,
+// the checked out branch can only be pushed to when
+// the worktree is clean and the worktree is then updated
+func checkCurrentBranchUpdate(directory string, commands []*packp.Command) (*packp.Command, error) {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	// fails again when loading the session
+	if err != nil {
+		return nil, nil
+	}
+
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+
+	if err != nil || head.Type() != plumbing.SymbolicReference {
+		return nil, nil
+	}
+
+	for _, command := range commands {
+		if command.Name != head.Target() {
+			continue
+		}
+
+		if command.Action() == packp.Delete {
+			return command, errors.New("deletion of the current branch prohibited")
+		}
+
+		worktree, err := getWorktree(repo)
+
+		if err != nil {
+			return command, err
+		}
+
+		status, err := worktreeStatus(repo, worktree)
+
+		if err != nil {
+			return command, err
+		}
+
+		wg.Wait()
+
+		if !status.IsClean() || mergeInProgress(repo) || sequencerInProgress(repo) {
+			return command, errors.New("working directory is not clean")
+		}
+
+		return command, nil
+	}
+
+	return nil, nil
+}
+
+func updateWorktree(directory string, hash plumbing.Hash) error {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return err
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		return err
+	}
+
+	err = worktree.Reset(&git.ResetOptions{
+		Commit: hash,
+		Mode:   git.HardReset,
+	})
+
+	wg.Wait()
+
+	return err
+}
+
+func receivePack(w http.ResponseWriter, r *http.Request, endpoint *transport.Endpoint, directory string) error {
+	body, err := requestBody(r)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	defer body.Close()
+
+	request := packp.NewReferenceUpdateRequest()
+	err = request.Decode(body)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	currentBranchCommand, refused := checkCurrentBranchUpdate(directory, request.Commands)
+
+	if refused != nil {
+		commands := []*packp.Command{}
+		for _, command := range request.Commands {
+			if command != currentBranchCommand {
+				commands = append(commands, command)
+			}
+		}
+		request.Commands = commands
+	}
+
+	session, err := gitTransportServer.NewReceivePackSession(endpoint, nil)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	defer session.Close()
+
+	report, err := session.ReceivePack(r.Context(), request)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	if report != nil && currentBranchCommand != nil && refused == nil {
+		for _, status := range report.CommandStatuses {
+			if status.ReferenceName == currentBranchCommand.Name && status.Error() == nil {
+				refused = updateWorktree(directory, currentBranchCommand.New)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-"+receivePackService+"-result")
+
+	// the client always expects a report
+	if report == nil {
+		report = packp.NewReportStatus()
+		report.UnpackStatus = "ok"
+		status := "not applied"
+		if err != nil {
+			report.UnpackStatus = err.Error()
+			status = err.Error()
+		}
+		for _, command := range request.Commands {
+			report.CommandStatuses = append(report.CommandStatuses, &packp.CommandStatus{
+				ReferenceName: command.Name,
+				Status:        status,
+			})
+		}
+	}
+
+	if refused != nil {
+		report.CommandStatuses = append(report.CommandStatuses, &packp.CommandStatus{
+			ReferenceName: currentBranchCommand.Name,
+			Status:        refused.Error(),
+		})
+	}
+
+	return report.Encode(w)
+}
+
+// non-loopback IPv4 addresses other devices can reach
+func lanAddresses() []string {
+	addresses := []string{}
+
+	interfaceAddresses, err := net.InterfaceAddrs()
+
+	if err != nil {
+		return addresses
+	}
+
+	for _, a := range interfaceAddresses {
+		ipNet, ok := a.(*net.IPNet)
+		if ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			addresses = append(addresses, ipNet.IP.String())
+		}
+	}
+
+	return addresses
+}
+
+func serializeServer(gitServer *GitServer) []byte {
+	data := serialize.SerializeNumber(float64(gitServer.Port))
+	data = append(data, serialize.SerializeString(gitServer.Password)...)
+	data = append(data, serialize.SerializeBoolean(gitServer.ReadOnly)...)
+	data = append(data, serialize.SerializeBoolean(gitServer.Exposed)...)
+
+	addresses := []string{"127.0.0.1"}
+	if gitServer.Exposed {
+		addresses = lanAddresses()
+	}
+
+	for _, address := range addresses {
+		data = append(data, serialize.SerializeString(address)...)
+	}
+
+	return data
+}
+
+// 0 picks a free port, only this device can connect
+// unless exposed to the local network,
+// returns [port, password, readOnly, exposed, ...addresses]
+func ServerStart(port int, readOnly bool, expose bool) []byte {
+	activeServerMutex.Lock()
+	defer activeServerMutex.Unlock()
+
+	// no sockets in the browser
+	if fs.WASM {
+		return serialize.SerializeString(errorFmt(ErrServerUnavailable))
+	}
+
+	if activeServer != nil {
+		return serialize.SerializeString(errorFmt(ErrServerRunning))
+	}
+
+	host := "127.0.0.1"
+	if expose {
+		host = ""
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	password := make([]byte, 16)
+	_, err = rand.Read(password)
+
+	if err != nil {
+		listener.Close()
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	gitServer := &GitServer{
+		Port:     listener.Addr().(*net.TCPAddr).Port,
+		Password: hex.EncodeToString(password),
+		ReadOnly: readOnly,
+		Exposed:  expose,
+	}
+
+	gitServer.server = &http.Server{
+		Handler: gitServer,
+	}
+
+	go func() {
+		err := gitServer.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			fmt.Println(err)
+		}
+	}()
+
+	activeServer = gitServer
+
+	return serializeServer(gitServer)
+}
+
+// same as ServerStart, empty when not running
+func ServerStatus() []byte {
+	activeServerMutex.Lock()
+	defer activeServerMutex.Unlock()
+
+	if activeServer == nil {
+		return nil
+	}
+
+	return serializeServer(activeServer)
+}
+
+func ServerStop() []byte {
+	activeServerMutex.Lock()
+	defer activeServerMutex.Unlock()
+
+	if activeServer == nil {
+		return serialize.SerializeString(errorFmt(ErrServerNotRunning))
+	}
+
+	err := activeServer.server.Close()
+	activeServer = nil
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	return nil
+}
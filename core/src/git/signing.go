@@ -0,0 +1,392 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgpErrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	fs "fullstackedorg/fullstacked/src/fs"
+	serialize "fullstackedorg/fullstacked/src/serialize"
+	setup "fullstackedorg/fullstacked/src/setup"
+)
+
+const (
+	gpgPrivateKeyFile = "private.asc"
+	// public keys trusted to verify signatures,
+	// includes the public key of the signing key
+	gpgKeyringFile = "keyring.asc"
+)
+
+var (
+	ErrNoPrivateKey        = errors.New("no private key found")
+	ErrPassphraseRequired  = errors.New("passphrase required for encrypted private key")
+	ErrPrivateKeyNotLocked = errors.New("private key is not passphrase protected")
+	ErrSigningKeyLocked    = errors.New("signing key is locked, unlock it with its passphrase to sign")
+)
+
+// passphrase protected key decrypted for the session,
+// only kept in memory
+var unlockedSignKey = (*openpgp.Entity)(nil)
+
+type SignatureStatus string
+
+const (
+	SIGNATURE_NONE    SignatureStatus = ""
+	SIGNATURE_GOOD    SignatureStatus = "good"
+	SIGNATURE_UNKNOWN SignatureStatus = "unknown"
+	SIGNATURE_BAD     SignatureStatus = "bad"
+)
+
+func gpgDirectory() string {
+	return path.Join(setup.Directories.Config, "gpg")
+}
+
+func readArmoredEntities(fileName string) openpgp.EntityList {
+	data, err := fs.ReadFile(path.Join(gpgDirectory(), fileName))
+
+	if err != nil {
+		return openpgp.EntityList{}
+	}
+
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+
+	if err != nil {
+		fmt.Println(err)
+		return openpgp.EntityList{}
+	}
+
+	return entities
+}
+
+func armorEntities(entities openpgp.EntityList, private bool) ([]byte, error) {
+	buf := bytes.Buffer{}
+
+	blockType := openpgp.PublicKeyType
+	if private {
+		blockType = openpgp.PrivateKeyType
+	}
+
+	w, err := armor.Encode(&buf, blockType, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entities {
+		// encrypted keys cannot re-sign their identities
+		if private {
+			err = e.SerializePrivateWithoutSigning(w, nil)
+		} else {
+			err = e.Serialize(w)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = w.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeArmoredEntities(fileName string, entities openpgp.EntityList, private bool) error {
+	data, err := armorEntities(entities, private)
+
+	if err != nil {
+		return err
+	}
+
+	if !fs.MkdirMode(gpgDirectory(), 0700, fileEventOrigin) {
+		return errors.New("failed to create gpg directory")
+	}
+
+	// readable by the owner only, like gpg
+	if private {
+		return fs.WriteFileMode(path.Join(gpgDirectory(), fileName), data, 0600, fileEventOrigin)
+	}
+
+	return fs.WriteFile(path.Join(gpgDirectory(), fileName), data, fileEventOrigin)
+}
+
+// adds the public keys to the keyring,
+// already trusted keys are skipped
+func trustEntities(entities openpgp.EntityList) error {
+	keyring := readArmoredEntities(gpgKeyringFile)
+
+	for _, e := range entities {
+		trusted := false
+		for _, k := range keyring {
+			if k.PrimaryKey.Fingerprint != nil && bytes.Equal(k.PrimaryKey.Fingerprint, e.PrimaryKey.Fingerprint) {
+				trusted = true
+				break
+			}
+		}
+
+		if !trusted {
+			keyring = append(keyring, e)
+		}
+	}
+
+	return writeArmoredEntities(gpgKeyringFile, keyring, false)
+}
+
+// nil when signing is not setup,
+// commits and annotated tags are signed with this key.
+// a passphrase protected key must be unlocked first
+func signKey() (*openpgp.Entity, error) {
+	entities := readArmoredEntities(gpgPrivateKeyFile)
+
+	if len(entities) == 0 || entities[0].PrivateKey == nil {
+		return nil, nil
+	}
+
+	if !entities[0].PrivateKey.Encrypted {
+		return entities[0], nil
+	}
+
+	if unlockedSignKey != nil && bytes.Equal(unlockedSignKey.PrimaryKey.Fingerprint, entities[0].PrimaryKey.Fingerprint) {
+		return unlockedSignKey, nil
+	}
+
+	return nil, ErrSigningKeyLocked
+}
+
+// the key is stored encrypted when a passphrase is given
+func setSignKey(entity *openpgp.Entity, passphrase string) error {
+	if passphrase != "" && !entity.PrivateKey.Encrypted {
+		err := entity.EncryptPrivateKeys([]byte(passphrase), nil)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err := writeArmoredEntities(gpgPrivateKeyFile, openpgp.EntityList{entity}, true)
+
+	if err != nil {
+		return err
+	}
+
+	unlockedSignKey = nil
+
+	err = trustEntities(openpgp.EntityList{entity})
+
+	if err != nil || passphrase == "" {
+		return err
+	}
+
+	return unlockSignKey(passphrase)
+}
+
+func unlockSignKey(passphrase string) error {
+	entities := readArmoredEntities(gpgPrivateKeyFile)
+
+	if len(entities) == 0 || entities[0].PrivateKey == nil {
+		return ErrNoPrivateKey
+	}
+
+	if !entities[0].PrivateKey.Encrypted {
+		return ErrPrivateKeyNotLocked
+	}
+
+	err := entities[0].DecryptPrivateKeys([]byte(passphrase))
+
+	if err != nil {
+		return err
+	}
+
+	unlockedSignKey = entities[0]
+
+	return nil
+}
+
+// parsed once per log, empty without trusted keys
+func trustedKeyring() openpgp.EntityList {
+	return readArmoredEntities(gpgKeyringFile)
+}
+
+// returns the status and the signer identity
+func verifyCommit(commit *object.Commit, keyring openpgp.EntityList) (SignatureStatus, string) {
+	if commit.PGPSignature == "" {
+		return SIGNATURE_NONE, ""
+	}
+
+	// ssh signatures cannot be verified
+	if len(keyring) == 0 || !strings.Contains(commit.PGPSignature, "BEGIN PGP SIGNATURE") {
+		return SIGNATURE_UNKNOWN, ""
+	}
+
+	// like commit.Verify, without parsing the keyring
+	encoded := &plumbing.MemoryObject{}
+	err := commit.EncodeWithoutSignature(encoded)
+
+	if err != nil {
+		return SIGNATURE_BAD, ""
+	}
+
+	signed, err := encoded.Reader()
+
+	if err != nil {
+		return SIGNATURE_BAD, ""
+	}
+
+	entity, err := openpgp.CheckArmoredDetachedSignature(keyring, signed, strings.NewReader(commit.PGPSignature), nil)
+
+	if err == pgpErrors.ErrUnknownIssuer {
+		return SIGNATURE_UNKNOWN, ""
+	} else if err != nil {
+		return SIGNATURE_BAD, ""
+	}
+
+	signer := ""
+	if identity := entity.PrimaryIdentity(); identity != nil {
+		signer = identity.Name
+	}
+
+	return SIGNATURE_GOOD, signer
+}
+
+// replaces the signing key, protected when a passphrase is given,
+// returns the armored public key
+func GpgKeyGenerate(name string, email string, passphrase string) []byte {
+	entity, err := openpgp.NewEntity(name, "", email, &packet.Config{
+		Algorithm: packet.PubKeyAlgoEdDSA,
+	})
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	err = setSignKey(entity, passphrase)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	publicKey, err := armorEntities(openpgp.EntityList{entity}, false)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	return serialize.SerializeString(string(publicKey))
+}
+
+// replaces the signing key with an armored private key,
+// an encrypted key is checked against the passphrase
+// and a clear one is encrypted with it
+func GpgKeyImport(armoredPrivateKey string, passphrase string) []byte {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armoredPrivateKey))
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	if len(entities) == 0 || entities[0].PrivateKey == nil {
+		return serialize.SerializeString(errorFmt(ErrNoPrivateKey))
+	}
+
+	if entities[0].PrivateKey.Encrypted {
+		if passphrase == "" {
+			return serialize.SerializeString(errorFmt(ErrPassphraseRequired))
+		}
+
+		// on a copy, the key is stored encrypted
+		decrypted, _ := openpgp.ReadArmoredKeyRing(strings.NewReader(armoredPrivateKey))
+		err = decrypted[0].DecryptPrivateKeys([]byte(passphrase))
+
+		if err != nil {
+			return serialize.SerializeString(errorFmt(err))
+		}
+	}
+
+	err = setSignKey(entities[0], passphrase)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	return nil
+}
+
+// stops signing, trusted keys are kept
+func GpgKeyDelete() []byte {
+	privateKeyFile := path.Join(gpgDirectory(), gpgPrivateKeyFile)
+
+	if exists, _ := fs.Exists(privateKeyFile); !exists {
+		return nil
+	}
+
+	unlockedSignKey = nil
+
+	err := fs.Unlink(privateKeyFile, fileEventOrigin)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	return nil
+}
+
+// decrypts the passphrase protected signing key
+// until the app is closed
+func GpgKeyUnlock(passphrase string) []byte {
+	err := unlockSignKey(passphrase)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	return nil
+}
+
+// empty string without signing key
+// the public part of a locked key is readable
+func GpgPublicKey() []byte {
+	entities := readArmoredEntities(gpgPrivateKeyFile)
+
+	if len(entities) == 0 {
+		return serialize.SerializeString("")
+	}
+
+	entity := entities[0]
+
+	publicKey, err := armorEntities(openpgp.EntityList{entity}, false)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	return serialize.SerializeString(string(publicKey))
+}
+
+// adds armored public keys used to verify signatures
+func GpgTrustKey(armoredPublicKey string) []byte {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armoredPublicKey))
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	err = trustEntities(entities)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	return nil
+}
@@ -0,0 +1,274 @@
+package git
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitSsh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	fs "fullstackedorg/fullstacked/src/fs"
+	serialize "fullstackedorg/fullstacked/src/serialize"
+	setup "fullstackedorg/fullstacked/src/setup"
+	utils "fullstackedorg/fullstacked/src/utils"
+)
+
+const (
+	sshPrivateKeyFile = "id_ed25519"
+	sshPublicKeyFile  = "id_ed25519.pub"
+	sshKnownHostsFile = "known_hosts"
+	sshDefaultUser    = "git"
+)
+
+var (
+	ErrHostKeyMismatch   = errors.New("host key mismatch")
+	ErrHostKeyNotTrusted = errors.New("host key not trusted")
+)
+
+func sshDirectory() string {
+	return path.Join(setup.Directories.Config, "ssh")
+}
+
+// git@host:org/repo and ssh://host/org/repo
+func sshEndpoint(urlStr string) *transport.Endpoint {
+	endpoint, err := transport.NewEndpoint(urlStr)
+
+	if err != nil || endpoint.Protocol != "ssh" {
+		return nil
+	}
+
+	return endpoint
+}
+
+// nil without generated key
+func sshAuth(endpoint *transport.Endpoint) transport.AuthMethod {
+	privateKey, err := fs.ReadFile(path.Join(sshDirectory(), sshPrivateKeyFile))
+
+	if err != nil {
+		return nil
+	}
+
+	user := endpoint.User
+	if user == "" {
+		user = sshDefaultUser
+	}
+
+	auth, err := gitSsh.NewPublicKeys(user, privateKey, "")
+
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+
+	auth.HostKeyCallback = verifyHostKey
+
+	return auth
+}
+
+// ssh remotes are checked with a tcp connection
+func isReachable(urlStr string) bool {
+	endpoint := sshEndpoint(urlStr)
+
+	if endpoint == nil {
+		return utils.IsReacheable(urlStr)
+	}
+
+	port := endpoint.Port
+	if port == 0 {
+		port = 22
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(endpoint.Host, fmt.Sprint(port)), time.Second*3)
+
+	if err != nil {
+		return false
+	}
+
+	conn.Close()
+
+	return true
+}
+
+func knownHostKeys(host string) []ssh.PublicKey {
+	data, err := fs.ReadFile(path.Join(sshDirectory(), sshKnownHostsFile))
+
+	if err != nil {
+		return nil
+	}
+
+	keys := []ssh.PublicKey{}
+
+	for len(data) > 0 {
+		_, hosts, key, _, rest, err := ssh.ParseKnownHosts(data)
+
+		if err != nil {
+			break
+		}
+
+		for _, h := range hosts {
+			if h == host {
+				keys = append(keys, key)
+				break
+			}
+		}
+
+		data = rest
+	}
+
+	return keys
+}
+
+func addKnownHost(host string, key ssh.PublicKey) error {
+	knownHostsFile := path.Join(sshDirectory(), sshKnownHostsFile)
+
+	data, _ := fs.ReadFile(knownHostsFile)
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	data = append(data, []byte(knownhosts.Line([]string{host}, key)+"\n")...)
+
+	fs.MkdirMode(sshDirectory(), 0700, fileEventOrigin)
+
+	return fs.WriteFile(knownHostsFile, data, fileEventOrigin)
+}
+
+// unknown hosts are trusted by the user,
+// a changed key is always refused
+func verifyHostKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	host := knownhosts.Normalize(hostname)
+
+	for _, k := range knownHostKeys(host) {
+		if k.Type() != key.Type() {
+			continue
+		}
+
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return nil
+		}
+
+		return ErrHostKeyMismatch
+	}
+
+	if !requestHostKeyTrust(host, key) {
+		return ErrHostKeyNotTrusted
+	}
+
+	return addKnownHost(host, key)
+}
+
+type HostKeyRequest struct {
+	Id          string          `json:"id"`
+	Host        string          `json:"host"`
+	KeyType     string          `json:"keyType"`
+	Fingerprint string          `json:"fingerprint"`
+	Trusted     bool            `json:"-"`
+	WaitGroup   *sync.WaitGroup `json:"-"`
+}
+
+var activeHostKeyRequests = map[string]*HostKeyRequest{}
+
+// returns trusted
+func requestHostKeyTrust(host string, key ssh.PublicKey) bool {
+	wg := sync.WaitGroup{}
+
+	hostKeyRequest := &HostKeyRequest{
+		Id:          utils.RandString(10),
+		Host:        host,
+		KeyType:     key.Type(),
+		Fingerprint: ssh.FingerprintSHA256(key),
+		WaitGroup:   &wg,
+	}
+
+	activeHostKeyRequests[hostKeyRequest.Id] = hostKeyRequest
+
+	wg.Add(1)
+
+	jsonData, _ := json.Marshal(hostKeyRequest)
+	jsonStr := string(jsonData)
+	setup.Callback("", "git-host-key", jsonStr)
+
+	wg.Wait()
+	defer delete(activeHostKeyRequests, hostKeyRequest.Id)
+
+	return hostKeyRequest.Trusted
+}
+
+func HostKeyResponse(id string, trusted bool) {
+	hostKeyRequest, ok := activeHostKeyRequests[id]
+
+	if !ok {
+		return
+	}
+
+	hostKeyRequest.Trusted = trusted
+	hostKeyRequest.WaitGroup.Done()
+}
+
+// replaces any existing key,
+// returns the public key in authorized_keys format
+func SshKeyGenerate(comment string) []byte {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	privateKeyPem, err := ssh.MarshalPrivateKey(privateKey, comment)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublicKey)))
+	if comment != "" {
+		authorizedKey += " " + comment
+	}
+
+	// readable by the owner only, like ssh-keygen
+	if !fs.MkdirMode(sshDirectory(), 0700, fileEventOrigin) {
+		return serialize.SerializeString(errorFmt(errors.New("failed to create ssh directory")))
+	}
+
+	err = fs.WriteFileMode(path.Join(sshDirectory(), sshPrivateKeyFile), pem.EncodeToMemory(privateKeyPem), 0600, fileEventOrigin)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	err = fs.WriteFile(path.Join(sshDirectory(), sshPublicKeyFile), []byte(authorizedKey+"\n"), fileEventOrigin)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	return serialize.SerializeString(authorizedKey)
+}
+
+// empty string without generated key
+func SshPublicKey() []byte {
+	publicKey, err := fs.ReadFile(path.Join(sshDirectory(), sshPublicKeyFile))
+
+	if err != nil {
+		return serialize.SerializeString("")
+	}
+
+	return serialize.SerializeString(strings.TrimSpace(string(publicKey)))
+}
@@ -0,0 +1,270 @@
+package git
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	git "github.com/go-git/go-git/v5"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	serialize "fullstackedorg/fullstacked/src/serialize"
+	setup "fullstackedorg/fullstacked/src/setup"
+)
+
+func setupSshTest(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is required to serve the repository")
+	}
+
+	// file events are sent after a debounce
+	if setup.Callback == nil {
+		setup.Callback = func(string, string, string) {}
+	}
+
+	previous := setup.Directories
+	t.Cleanup(func() { setup.Directories = previous })
+
+	tmp := t.TempDir()
+	setup.SetupDirectories(tmp, filepath.Join(tmp, "config"), tmp, filepath.Join(tmp, "tmp"))
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@test",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@test",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s", strings.Join(args, " "), out)
+	}
+}
+
+// bare repository with a single commit on main
+func newBareRepo(t *testing.T) string {
+	work := t.TempDir()
+	runGit(t, work, "init", "-q", "-b", "main")
+	os.WriteFile(filepath.Join(work, "README.md"), []byte("ssh\n"), 0644)
+	runGit(t, work, "add", ".")
+	runGit(t, work, "commit", "-qm", "initial")
+
+	bare := filepath.Join(t.TempDir(), "repo.git")
+	runGit(t, work, "clone", "-q", "--bare", work, bare)
+
+	return bare
+}
+
+// ssh server running the git commands it receives,
+// only the authorized key is accepted
+func startSshServer(t *testing.T, authorized ssh.PublicKey) (string, ssh.PublicKey) {
+	_, hostPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(authorized.Marshal()) {
+				return nil, ssh.ErrNoAuth
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSshConn(conn, config)
+		}
+	}()
+
+	return listener.Addr().String(), hostSigner.PublicKey()
+}
+
+func serveSshConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "")
+			continue
+		}
+
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go func() {
+			for request := range channelRequests {
+				if request.Type != "exec" || len(request.Payload) < 4 {
+					request.Reply(false, nil)
+					continue
+				}
+				request.Reply(true, nil)
+
+				command := string(request.Payload[4:])
+				go runSshCommand(channel, command)
+			}
+		}()
+	}
+}
+
+func runSshCommand(channel ssh.Channel, command string) {
+	defer channel.Close()
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = channel
+	cmd.Stderr = channel.Stderr()
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return
+	}
+
+	status := uint32(0)
+	if err := cmd.Start(); err != nil {
+		status = 127
+	} else {
+		go func() {
+			io.Copy(stdin, channel)
+			stdin.Close()
+		}()
+
+		if cmd.Wait() != nil {
+			status = 1
+		}
+	}
+
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, status)
+	channel.SendRequest("exit-status", false, payload)
+}
+
+func generateSshKey(t *testing.T) ssh.PublicKey {
+	args := serialize.DeserializeArgs(SshKeyGenerate("test"))
+	if len(args) != 1 {
+		t.Fatalf("unexpected key generation result %v", args)
+	}
+
+	authorizedKey := args[0].(string)
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		t.Fatalf("%s: %v", authorizedKey, err)
+	}
+
+	return publicKey
+}
+
+func TestSshKeyGeneratePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix permissions")
+	}
+	setupSshTest(t)
+
+	// an existing directory is restricted too
+	os.MkdirAll(sshDirectory(), 0755)
+
+	generateSshKey(t)
+
+	expected := map[string]os.FileMode{
+		sshDirectory(): 0700,
+		filepath.Join(sshDirectory(), sshPrivateKeyFile): 0600,
+	}
+	for file, mode := range expected {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("%s: expected %o, got %o", file, mode, info.Mode().Perm())
+		}
+	}
+}
+
+func TestSshClone(t *testing.T) {
+	setupSshTest(t)
+
+	publicKey := generateSshKey(t)
+	addr, hostKey := startSshServer(t, publicKey)
+
+	err := addKnownHost(knownhosts.Normalize(addr), hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bare := newBareRepo(t)
+	url := "ssh://git@" + addr + filepath.ToSlash(bare)
+
+	repo, err := git.PlainClone(t.TempDir(), false, &git.CloneOptions{
+		URL:  url,
+		Auth: sshAuth(sshEndpoint(url)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Name().Short() != "main" {
+		t.Errorf("expected main, got %s", head.Name().Short())
+	}
+}
+
+func TestSshHostKeyMismatch(t *testing.T) {
+	setupSshTest(t)
+
+	publicKey := generateSshKey(t)
+	addr, _ := startSshServer(t, publicKey)
+
+	// known with another key
+	_, otherPrivateKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := ssh.NewSignerFromKey(otherPrivateKey)
+	err := addKnownHost(knownhosts.Normalize(addr), otherSigner.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bare := newBareRepo(t)
+	url := "ssh://git@" + addr + filepath.ToSlash(bare)
+
+	_, err = git.PlainClone(t.TempDir(), false, &git.CloneOptions{
+		URL:  url,
+		Auth: sshAuth(sshEndpoint(url)),
+	})
+	if err == nil || !strings.Contains(err.Error(), ErrHostKeyMismatch.Error()) {
+		t.Fatalf("expected %v, got %v", ErrHostKeyMismatch, err)
+	}
+}
@@ -0,0 +1,139 @@
+package git
+
+import (
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	serialize "fullstackedorg/fullstacked/src/serialize"
+)
+
+// files can be directories,
+// deleted files are removed from the index
+func Add(directory string, files []string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	for _, file := range files {
+		err = worktree.AddWithOptions(&git.AddOptions{
+			Path: file,
+		})
+
+		if err != nil {
+			return serialize.SerializeString(errorFmt(err))
+		}
+	}
+
+	err = lfsCleanIndex(repo, worktree)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+// resets the index entries of files to HEAD,
+// leaves the worktree untouched
+func Unstage(directory string, files []string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	tree, err := headTree(repo)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	idx, err := repo.Storer.Index()
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	for _, file := range files {
+		err = unstageFile(idx, tree, file)
+
+		if err != nil {
+			return serialize.SerializeString(errorFmt(err))
+		}
+	}
+
+	err = repo.Storer.SetIndex(idx)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+func unstageFile(idx *index.Index, tree *object.Tree, file string) error {
+	matches := pathFilter(file)
+
+	headFiles := map[string]*object.File{}
+	if tree != nil {
+		err := tree.Files().ForEach(func(f *object.File) error {
+			if matches(f.Name) {
+				headFiles[f.Name] = f
+			}
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, e := range append([]*index.Entry{}, idx.Entries...) {
+		if !matches(e.Name) {
+			continue
+		}
+
+		if _, ok := headFiles[e.Name]; !ok {
+			idx.Remove(e.Name)
+		}
+	}
+
+	for name, f := range headFiles {
+		entry, err := idx.Entry(name)
+		if err != nil {
+			entry = idx.Add(name)
+		}
+
+		if entry.Hash == f.Hash && entry.Mode == f.Mode {
+			continue
+		}
+
+		// drop the cached stat so status compares content
+		*entry = index.Entry{
+			Name: name,
+			Hash: f.Hash,
+			Mode: f.Mode,
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,587 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	serialize "fullstackedorg/fullstacked/src/serialize"
+)
+
+const (
+	stashRef    = "refs/stash"
+	stashLogRef = "logs/refs/stash"
+)
+
+var ErrNoLocalChanges = errors.New("no local changes to save")
+var ErrStashNotFound = errors.New("stash entry not found")
+
+// stash@{0} is the most recent
+type StashEntry struct {
+	Index   int
+	Hash    plumbing.Hash
+	Message string
+	When    time.Time
+	// <name> <<email>> of the reflog line
+	Identity string
+}
+
+// refs/stash reflog lines,
+// <old> <new> <name> <<email>> <timestamp> <tz>\t<message>
+func readStashLog(repo *git.Repository) []StashEntry {
+	entries := []StashEntry{}
+
+	data, err := util.ReadFile(dotGitFs(repo), stashLogRef)
+	if err != nil {
+		return entries
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		header, message, ok := strings.Cut(lines[i], "\t")
+		if !ok {
+			continue
+		}
+
+		fields := strings.Fields(header)
+		if len(fields) < 4 {
+			continue
+		}
+
+		when := time.Time{}
+		timestamp, err := strconv.ParseInt(fields[len(fields)-2], 10, 64)
+		if err == nil {
+			when = time.Unix(timestamp, 0)
+		}
+
+		entries = append(entries, StashEntry{
+			Index:    len(entries),
+			Hash:     plumbing.NewHash(fields[1]),
+			Message:  message,
+			When:     when,
+			Identity: strings.Join(fields[2:len(fields)-2], " "),
+		})
+	}
+
+	return entries
+}
+
+func stashIdentity(sig object.Signature) string {
+	return sig.Name + " <" + sig.Email + ">"
+}
+
+// each entry keeps the identity it was stashed with
+func writeStashLog(repo *git.Repository, entries []StashEntry) error {
+	gitFs := dotGitFs(repo)
+
+	if len(entries) == 0 {
+		gitFs.Remove(stashLogRef)
+		return repo.Storer.RemoveReference(stashRef)
+	}
+
+	lines := []string{}
+	previous := plumbing.ZeroHash
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		lines = append(lines, fmt.Sprintf("%s %s %s %d %s\t%s",
+			previous.String(),
+			e.Hash.String(),
+			e.Identity,
+			e.When.Unix(),
+			e.When.Format("-0700"),
+			e.Message,
+		))
+		previous = e.Hash
+	}
+
+	err := util.WriteFile(gitFs, stashLogRef, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+
+	return repo.Storer.SetReference(plumbing.NewHashReference(stashRef, entries[0].Hash))
+}
+
+func stashEntries(repo *git.Repository) []StashEntry {
+	entries := readStashLog(repo)
+
+	// stash created without reflog
+	if len(entries) == 0 {
+		ref, err := repo.Reference(stashRef, true)
+		if err == nil {
+			commit, err := repo.CommitObject(ref.Hash())
+			if err == nil {
+				entries = append(entries, StashEntry{
+					Index:    0,
+					Hash:     commit.Hash,
+					Message:  strings.TrimSpace(commit.Message),
+					When:     commit.Committer.When,
+					Identity: stashIdentity(commit.Committer),
+				})
+			}
+		}
+	}
+
+	return entries
+}
+
+func stashEntry(repo *git.Repository, index int) (*StashEntry, error) {
+	entries := stashEntries(repo)
+
+	if index < 0 || index >= len(entries) {
+		return nil, ErrStashNotFound
+	}
+
+	return &entries[index], nil
+}
+
+// like git stash --include-untracked,
+// the stash commit has HEAD, the index commit
+// and the untracked files commit as parents
+func stashPush(repo *git.Repository, worktree *git.Worktree, message string, sig object.Signature) (*StashEntry, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	headCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := worktreeStatus(repo, worktree)
+	if err != nil {
+		return nil, err
+	}
+
+	if status.IsClean() {
+		return nil, ErrNoLocalChanges
+	}
+
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	indexFiles := map[string]treeEntry{}
+	worktreeFiles := map[string]treeEntry{}
+	for _, e := range idx.Entries {
+		indexFiles[e.Name] = treeEntry{e.Hash, e.Mode}
+		worktreeFiles[e.Name] = treeEntry{e.Hash, e.Mode}
+	}
+
+	untrackedFiles := map[string]treeEntry{}
+
+	// moved submodules are left as is, like git does
+	changed := false
+	for file, fileStatus := range status {
+		if e, ok := indexFiles[file]; ok && e.Mode == filemode.Submodule {
+			continue
+		}
+
+		if fileStatus.Staging != git.Unmodified && fileStatus.Staging != git.Untracked {
+			changed = true
+		}
+
+		if fileStatus.Worktree == git.Unmodified {
+			continue
+		}
+
+		changed = true
+
+		if fileStatus.Worktree == git.Deleted {
+			delete(worktreeFiles, file)
+			continue
+		}
+
+		// nested repository not in the index
+		if info, err := worktree.Filesystem.Lstat(file); err == nil && info.IsDir() {
+			continue
+		}
+
+		contents, err := util.ReadFile(worktree.Filesystem, file)
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := writeBlob(repo, contents)
+		if err != nil {
+			return nil, err
+		}
+
+		mode := filemode.Regular
+		if e, ok := indexFiles[file]; ok {
+			mode = e.Mode
+		}
+
+		if fileStatus.Worktree == git.Untracked {
+			untrackedFiles[file] = treeEntry{hash, mode}
+		} else {
+			worktreeFiles[file] = treeEntry{hash, mode}
+		}
+	}
+
+	if !changed {
+		return nil, ErrNoLocalChanges
+	}
+
+	subject := strings.Split(strings.TrimSpace(headCommit.Message), "\n")[0]
+	onBranch := fmt.Sprintf("%s: %s %s", head.Name().Short(), head.Hash().String()[:7], subject)
+
+	indexTree, err := writeTree(repo, indexFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	indexCommit, err := writeCommit(repo, &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      "index on " + onBranch + "\n",
+		TreeHash:     indexTree,
+		ParentHashes: []plumbing.Hash{head.Hash()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	parents := []plumbing.Hash{head.Hash(), indexCommit}
+
+	if len(untrackedFiles) > 0 {
+		untrackedTree, err := writeTree(repo, untrackedFiles)
+		if err != nil {
+			return nil, err
+		}
+
+		untrackedCommit, err := writeCommit(repo, &object.Commit{
+			Author:    sig,
+			Committer: sig,
+			Message:   "untracked files on " + onBranch + "\n",
+			TreeHash:  untrackedTree,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		parents = append(parents, untrackedCommit)
+	}
+
+	worktreeTree, err := writeTree(repo, worktreeFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	if message == "" {
+		message = "WIP on " + onBranch
+	} else {
+		message = "On " + head.Name().Short() + ": " + message
+	}
+
+	stashCommit, err := writeCommit(repo, &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      message + "\n",
+		TreeHash:     worktreeTree,
+		ParentHashes: parents,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entry := StashEntry{
+		Index:    0,
+		Hash:     stashCommit,
+		Message:  message,
+		When:     sig.When,
+		Identity: stashIdentity(sig),
+	}
+
+	err = writeStashLog(repo, append([]StashEntry{entry}, stashEntries(repo)...))
+	if err != nil {
+		return nil, err
+	}
+
+	// back to a clean worktree
+	err = worktree.Reset(&git.ResetOptions{
+		Commit: head.Hash(),
+		Mode:   git.HardReset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	headTree, err := headCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	for file := range status {
+		if _, err := headTree.File(file); err != nil {
+			worktree.Filesystem.Remove(file)
+		}
+	}
+
+	return &entry, nil
+}
+
+// restores the stashed changes unstaged,
+// files added to the index stay staged
+func stashApply(repo *git.Repository, worktree *git.Worktree, entry *StashEntry) ([]string, error) {
+	stashCommit, err := repo.CommitObject(entry.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stashCommit.ParentHashes) < 2 {
+		return nil, errors.New("not a stash commit")
+	}
+
+	status, err := worktreeStatus(repo, worktree)
+	if err != nil {
+		return nil, err
+	}
+
+	for file, fileStatus := range status {
+		if fileStatus.Worktree != git.Untracked {
+			return nil, errors.New("local changes would be overwritten: " + file)
+		}
+	}
+
+	baseCommit, err := repo.CommitObject(stashCommit.ParentHashes[0])
+	if err != nil {
+		return nil, err
+	}
+	indexCommit, err := repo.CommitObject(stashCommit.ParentHashes[1])
+	if err != nil {
+		return nil, err
+	}
+
+	untrackedFiles := map[string]*object.File{}
+	if len(stashCommit.ParentHashes) > 2 {
+		untrackedCommit, err := repo.CommitObject(stashCommit.ParentHashes[2])
+		if err != nil {
+			return nil, err
+		}
+
+		untrackedTree, err := untrackedCommit.Tree()
+		if err != nil {
+			return nil, err
+		}
+
+		untrackedFiles, err = treeFiles(untrackedTree)
+		if err != nil {
+			return nil, err
+		}
+
+		for file := range untrackedFiles {
+			if _, err := worktree.Filesystem.Lstat(file); err == nil {
+				return nil, errors.New("untracked file already exists: " + file)
+			}
+		}
+	}
+
+	baseTree, err := baseCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	indexTree, err := indexCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	stashTree, err := stashCommit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	oursTree, err := headTree(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts, err := mergeTrees(repo, worktree, baseTree, oursTree, stashTree, "Updated upstream", "Stashed changes")
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTree(baseTree, stashTree)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, change := range changes {
+		name := change.To.Name
+		if name == "" {
+			name = change.From.Name
+		}
+
+		_, inBase := baseTree.File(name)
+		_, inIndex := indexTree.File(name)
+		if inBase != nil && inIndex == nil {
+			continue
+		}
+
+		err = unstageFile(idx, oursTree, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = repo.Storer.SetIndex(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, f := range untrackedFiles {
+		contents, err := f.Contents()
+		if err != nil {
+			return nil, err
+		}
+
+		err = util.WriteFile(worktree.Filesystem, name, []byte(contents), osFileMode(f))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return conflicts, nil
+}
+
+func stashDrop(repo *git.Repository, index int) error {
+	entries := stashEntries(repo)
+
+	if index < 0 || index >= len(entries) {
+		return ErrStashNotFound
+	}
+
+	entries = append(entries[:index], entries[index+1:]...)
+
+	return writeStashLog(repo, entries)
+}
+
+func StashPush(directory string, message string, authorName string, authorEmail string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	_, err = stashPush(repo, worktree, message, signature(repo, authorName, authorEmail))
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+func StashList(directory string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	data := []byte{}
+
+	// [hash, message, when, hash, message, when, ...]
+	for _, e := range stashEntries(repo) {
+		data = append(data, serialize.SerializeString(e.Hash.String())...)
+		data = append(data, serialize.SerializeString(e.Message)...)
+		data = append(data, serialize.SerializeNumber(float64(e.When.UnixMilli()))...)
+	}
+
+	wg.Wait()
+
+	return data
+}
+
+// returns the conflicted files,
+// a conflicting stash is never dropped
+func StashApply(directory string, index int, pop bool) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	entry, err := stashEntry(repo, index)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	conflicts, err := stashApply(repo, worktree, entry)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	if pop && len(conflicts) == 0 {
+		err = stashDrop(repo, index)
+
+		if err != nil {
+			return serialize.SerializeString(errorFmt(err))
+		}
+	}
+
+	wg.Wait()
+
+	data := []byte{}
+	for _, c := range conflicts {
+		data = append(data, serialize.SerializeString(c)...)
+	}
+
+	return data
+}
+
+func StashDrop(directory string, index int) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	err = stashDrop(repo, index)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	wg.Wait()
+
+	return nil
+}
@@ -0,0 +1,637 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	fs "fullstackedorg/fullstacked/src/fs"
+	serialize "fullstackedorg/fullstacked/src/serialize"
+	setup "fullstackedorg/fullstacked/src/setup"
+)
+
+// go-git hashes every file of the worktree and walks the
+// ignored directories on each status, this status only hashes
+// the files whose mtime or size changed since they were last hashed
+// and never enters ignored directories
+
+// in .git, one "mtime size hash lfs path" line per file
+const statusCacheFile = "fullstacked-status-cache"
+
+// files modified this recently could change again
+// without their mtime changing, they are never cached
+const statusCacheRacyDelay = 2 * time.Second
+
+// untracked files are reported by batches of this size
+const statusBatchSize = 256
+
+type statusCacheEntry struct {
+	MTime int64
+	Size  int64
+	// blob hash of the content, of its pointer for lfs files
+	Hash plumbing.Hash
+	Lfs  bool
+}
+
+type statusCache struct {
+	mutex   sync.Mutex
+	loaded  bool
+	dirty   bool
+	entries map[string]statusCacheEntry
+}
+
+var statusCaches = map[string]*statusCache{}
+var statusCachesMutex = sync.Mutex{}
+
+func getStatusCache(directory string) *statusCache {
+	statusCachesMutex.Lock()
+	defer statusCachesMutex.Unlock()
+
+	cache, ok := statusCaches[directory]
+
+	if !ok {
+		cache = &statusCache{
+			entries: map[string]statusCacheEntry{},
+		}
+		statusCaches[directory] = cache
+	}
+
+	return cache
+}
+
+func (cache *statusCache) load(repo *git.Repository) {
+	if cache.loaded {
+		return
+	}
+	cache.loaded = true
+
+	data, err := util.ReadFile(dotGitFs(repo), statusCacheFile)
+
+	if err != nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 5)
+
+		if len(fields) != 5 {
+			continue
+		}
+
+		mTime, _ := strconv.ParseInt(fields[0], 10, 64)
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+
+		cache.entries[fields[4]] = statusCacheEntry{
+			MTime: mTime,
+			Size:  size,
+			Hash:  plumbing.NewHash(fields[2]),
+			Lfs:   fields[3] == "1",
+		}
+	}
+}
+
+// only the tracked files are kept
+func (cache *statusCache) save(repo *git.Repository, tracked map[string]*index.Entry) error {
+	if !cache.dirty {
+		return nil
+	}
+
+	data := bytes.Buffer{}
+
+	for file, entry := range cache.entries {
+		if _, ok := tracked[file]; !ok {
+			delete(cache.entries, file)
+			continue
+		}
+
+		lfs := "0"
+		if entry.Lfs {
+			lfs = "1"
+		}
+
+		data.WriteString(strconv.FormatInt(entry.MTime, 10) + " " +
+			strconv.FormatInt(entry.Size, 10) + " " +
+			entry.Hash.String() + " " +
+			lfs + " " +
+			file + "\n")
+	}
+
+	cache.dirty = false
+
+	return util.WriteFile(dotGitFs(repo), statusCacheFile, data.Bytes(), 0644)
+}
+
+// hash the content would have once staged
+func worktreeFileHash(directory string, file string, lfs bool) (plumbing.Hash, error) {
+	content, err := fs.ReadFile(path.Join(directory, file))
+
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if lfs {
+		if _, isPointer := parseLfsPointer(content); !isPointer {
+			content = newLfsPointer(content).encode()
+		}
+	}
+
+	return plumbing.ComputeHash(plumbing.BlobObject, content), nil
+}
+
+type statusScan struct {
+	repo      *git.Repository
+	worktree  *git.Worktree
+	directory string
+	cache     *statusCache
+	lfs       gitattributes.Matcher
+	// merged index entries by path
+	tracked map[string]*index.Entry
+	// stats of the files found by the walk
+	stats  map[string]fs.FileInfo2
+	status git.Status
+	// receives the changes as they are found
+	onChanges func(changes git.Status)
+}
+
+func (scan *statusScan) set(file string, staging git.StatusCode, worktree git.StatusCode) {
+	scan.status[file] = &git.FileStatus{
+		Staging:  staging,
+		Worktree: worktree,
+	}
+}
+
+func (scan *statusScan) emit(files []string) {
+	if scan.onChanges == nil || len(files) == 0 {
+		return
+	}
+
+	changes := git.Status{}
+	for _, file := range files {
+		changes[file] = scan.status[file]
+	}
+
+	scan.onChanges(changes)
+}
+
+// treeFiles loads the blob of each file
+func treeEntries(tree *object.Tree) (map[string]object.TreeEntry, error) {
+	entries := map[string]object.TreeEntry{}
+
+	if tree == nil {
+		return entries, nil
+	}
+
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	for {
+		name, entry, err := walker.Next()
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if entry.Mode != filemode.Dir {
+			entries[name] = entry
+		}
+	}
+
+	return entries, nil
+}
+
+// HEAD compared to the index
+func (scan *statusScan) staging() error {
+	tree, err := headTree(scan.repo)
+
+	if err != nil {
+		return err
+	}
+
+	headFiles, err := treeEntries(tree)
+
+	if err != nil {
+		return err
+	}
+
+	for file, entry := range scan.tracked {
+		headFile, ok := headFiles[file]
+
+		if !ok {
+			scan.set(file, git.Added, git.Unmodified)
+		} else if headFile.Hash != entry.Hash || headFile.Mode != entry.Mode {
+			scan.set(file, git.Modified, git.Unmodified)
+		}
+	}
+
+	for file := range headFiles {
+		if _, ok := scan.tracked[file]; ok {
+			continue
+		}
+
+		worktreeStatus := git.Unmodified
+		if exists, isFile := fs.Exists(path.Join(scan.directory, file)); exists && isFile {
+			worktreeStatus = git.Untracked
+		}
+
+		scan.set(file, git.Deleted, worktreeStatus)
+	}
+
+	return nil
+}
+
+func (scan *statusScan) setWorktree(file string, code git.StatusCode) {
+	fileStatus, ok := scan.status[file]
+
+	if !ok {
+		scan.set(file, git.Unmodified, code)
+	} else {
+		fileStatus.Worktree = code
+	}
+}
+
+// the index compared to the worktree,
+// files are hashed only when their stats changed
+func (scan *statusScan) trackedFiles() {
+	now := time.Now()
+
+	for file, entry := range scan.tracked {
+		// submodules are compared on their commit separately
+		if entry.Mode == filemode.Submodule {
+			continue
+		}
+
+		// not walked when in an ignored directory
+		stats, ok := scan.stats[file]
+		if !ok {
+			fileStats, err := fs.Stat(path.Join(scan.directory, file))
+
+			if err != nil || fileStats.IsDir {
+				scan.setWorktree(file, git.Deleted)
+				continue
+			}
+
+			stats = *fileStats
+		}
+
+		// the target of symlinks is not compared
+		if entry.Mode == filemode.Symlink {
+			continue
+		}
+
+		lfs := lfsTracked(scan.lfs, file)
+
+		// entries staged by merges and unstage have no size
+		if !lfs && entry.Size != 0 && int64(entry.Size) != stats.Size {
+			scan.setWorktree(file, git.Modified)
+			continue
+		}
+
+		mTime := stats.MTime.UnixNano()
+		cached, ok := scan.cache.entries[file]
+		hash := cached.Hash
+
+		if !ok || cached.MTime != mTime || cached.Size != stats.Size || cached.Lfs != lfs {
+			var err error
+			hash, err = worktreeFileHash(scan.directory, file, lfs)
+
+			if err != nil {
+				scan.setWorktree(file, git.Deleted)
+				continue
+			}
+
+			if now.Sub(stats.MTime) > statusCacheRacyDelay {
+				scan.cache.entries[file] = statusCacheEntry{
+					MTime: mTime,
+					Size:  stats.Size,
+					Hash:  hash,
+					Lfs:   lfs,
+				}
+				scan.cache.dirty = true
+			}
+		}
+
+		if hash != entry.Hash {
+			scan.setWorktree(file, git.Modified)
+		}
+	}
+}
+
+// .git/info/exclude, the FullStacked directories and
+// the .gitignore of each directory walked apply below it
+func (scan *statusScan) rootPatterns() []gitignore.Pattern {
+	patterns := append([]gitignore.Pattern{}, scan.worktree.Excludes...)
+
+	exclude, err := util.ReadFile(dotGitFs(scan.repo), "info/exclude")
+
+	if err == nil {
+		patterns = append(patterns, parseIgnoreFile(exclude, nil)...)
+	}
+
+	return patterns
+}
+
+func parseIgnoreFile(content []byte, domain []string) []gitignore.Pattern {
+	patterns := []gitignore.Pattern{}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		patterns = append(patterns, gitignore.ParsePattern(line, domain))
+	}
+
+	return patterns
+}
+
+// reports the untracked files of dir and its sub directories
+func (scan *statusScan) untrackedFiles(dir string, patterns []gitignore.Pattern, batch *[]string) {
+	items, err := fs.ReadDir(path.Join(scan.directory, dir), false, false, []string{})
+
+	if err != nil {
+		return
+	}
+
+	domain := []string{}
+	if dir != "" {
+		domain = strings.Split(dir, "/")
+	}
+
+	for _, item := range items {
+		if item.Name == ".gitignore" && !item.IsDir {
+			content, err := fs.ReadFile(path.Join(scan.directory, dir, item.Name))
+
+			if err == nil {
+				patterns = append(patterns[:len(patterns):len(patterns)], parseIgnoreFile(content, domain)...)
+			}
+		}
+
+		// nested repository
+		if item.Name == ".git" && dir != "" {
+			return
+		}
+	}
+
+	matcher := gitignore.NewMatcher(patterns)
+
+	for _, item := range items {
+		if item.Name == ".git" {
+			continue
+		}
+
+		file := path.Join(dir, item.Name)
+
+		if matcher.Match(append(domain[:len(domain):len(domain)], item.Name), item.IsDir) {
+			continue
+		}
+
+		if item.IsDir {
+			if entry, ok := scan.tracked[file]; ok && entry.Mode == filemode.Submodule {
+				continue
+			}
+
+			scan.untrackedFiles(file, patterns, batch)
+			continue
+		}
+
+		if _, ok := scan.tracked[file]; ok {
+			scan.stats[file] = item
+			continue
+		}
+
+		if _, ok := scan.status[file]; ok {
+			continue
+		}
+
+		scan.set(file, git.Untracked, git.Untracked)
+
+		*batch = append(*batch, file)
+		if len(*batch) >= statusBatchSize {
+			scan.emit(*batch)
+			*batch = []string{}
+		}
+	}
+}
+
+// onChanges receives the changes in batches, first the untracked
+// files as they are found then the changes of the tracked files
+func fastStatus(
+	repo *git.Repository,
+	worktree *git.Worktree,
+	directory string,
+	onChanges func(changes git.Status),
+) (git.Status, error) {
+	idx, err := repo.Storer.Index()
+
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := map[string]*index.Entry{}
+	for _, entry := range idx.Entries {
+		if _, ok := tracked[entry.Name]; !ok || entry.Stage == 0 {
+			tracked[entry.Name] = entry
+		}
+	}
+
+	cache := getStatusCache(directory)
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.load(repo)
+
+	scan := &statusScan{
+		repo:      repo,
+		worktree:  worktree,
+		directory: directory,
+		cache:     cache,
+		lfs:       lfsIndexMatcher(idx, worktree),
+		tracked:   tracked,
+		stats:     map[string]fs.FileInfo2{},
+		status:    git.Status{},
+		onChanges: onChanges,
+	}
+
+	err = scan.staging()
+
+	if err != nil {
+		return nil, err
+	}
+
+	batch := []string{}
+	scan.untrackedFiles("", scan.rootPatterns(), &batch)
+	scan.emit(batch)
+
+	untracked := map[string]bool{}
+	for file, fileStatus := range scan.status {
+		if fileStatus.Staging == git.Untracked {
+			untracked[file] = true
+		}
+	}
+
+	scan.trackedFiles()
+
+	err = submodulesChanges(worktree, scan.status)
+
+	if err != nil {
+		return nil, err
+	}
+
+	changed := []string{}
+	for file := range scan.status {
+		if !untracked[file] {
+			changed = append(changed, file)
+		}
+	}
+	scan.emit(changed)
+
+	err = cache.save(repo, tracked)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return scan.status, nil
+}
+
+// submodules not on their recorded commit
+// or with local changes are modified
+func submodulesChanges(worktree *git.Worktree, status git.Status) error {
+	submodules, err := submodulesStatus(worktree)
+
+	if err != nil {
+		return err
+	}
+
+	for _, s := range submodules {
+		if s.Current == "" || (s.Current == s.Expected && s.Clean) {
+			continue
+		}
+
+		fileStatus, ok := status[s.Path]
+		if !ok {
+			status[s.Path] = &git.FileStatus{
+				Staging:  git.Unmodified,
+				Worktree: git.Modified,
+			}
+		} else if fileStatus.Worktree == git.Unmodified {
+			fileStatus.Worktree = git.Modified
+		}
+	}
+
+	return nil
+}
+
+// [file, staging, worktree, file, staging, worktree, ...]
+func serializeStatus(status git.Status) []byte {
+	data := []byte{}
+
+	for file, fileStatus := range status {
+		data = append(data, serialize.SerializeString(file)...)
+		data = append(data, serialize.SerializeNumber(float64(statusCode(fileStatus.Staging)))...)
+		data = append(data, serialize.SerializeNumber(float64(statusCode(fileStatus.Worktree)))...)
+	}
+
+	return data
+}
+
+func Status(directory string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	status, err := fastStatus(repo, worktree, directory, nil)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	wg.Wait()
+
+	return serializeStatus(status)
+}
+
+type StatusMessageJSON struct {
+	Id float64 `json:"id"`
+	// [file, staging, worktree, file, staging, worktree, ...]
+	Changes  []any  `json:"changes"`
+	Finished bool   `json:"finished"`
+	Error    string `json:"error,omitempty"`
+}
+
+func statusMessage(projectId string, message StatusMessageJSON) {
+	jsonData, _ := json.Marshal(message)
+	setup.Callback(projectId, "git-status", string(jsonData))
+}
+
+// same as Status, the changes are sent in "git-status"
+// messages as they are found for large worktrees
+func StatusStream(directory string, projectId string, id float64) {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		statusMessage(projectId, StatusMessageJSON{Id: id, Finished: true, Error: err.Error()})
+		return
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		statusMessage(projectId, StatusMessageJSON{Id: id, Finished: true, Error: err.Error()})
+		return
+	}
+
+	_, err = fastStatus(repo, worktree, directory, func(changes git.Status) {
+		message := StatusMessageJSON{Id: id, Changes: []any{}}
+
+		for file, fileStatus := range changes {
+			message.Changes = append(message.Changes,
+				file,
+				statusCode(fileStatus.Staging),
+				statusCode(fileStatus.Worktree))
+		}
+
+		statusMessage(projectId, message)
+	})
+
+	wg.Wait()
+
+	if err != nil {
+		statusMessage(projectId, StatusMessageJSON{Id: id, Finished: true, Error: err.Error()})
+		return
+	}
+
+	statusMessage(projectId, StatusMessageJSON{Id: id, Changes: []any{}, Finished: true})
+}
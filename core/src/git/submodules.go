@@ -0,0 +1,249 @@
+package git
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5/util"
+	git "github.com/go-git/go-git/v5"
+
+	serialize "fullstackedorg/fullstacked/src/serialize"
+)
+
+// go-git resolves the .git file and core.worktree of submodules
+// from the billy fs roots, which are not the real directories
+func fixSubmodulePaths(repo *git.Repository, worktree *git.Worktree, directory string, gitDir string) error {
+	dotGit, err := filepath.Rel(directory, gitDir)
+
+	if err != nil {
+		return err
+	}
+
+	err = util.WriteFile(worktree.Filesystem, ".git", []byte("gitdir: "+filepath.ToSlash(dotGit)+"\n"), 0644)
+
+	if err != nil {
+		return err
+	}
+
+	cfg, err := repo.Config()
+
+	if err != nil {
+		return err
+	}
+
+	coreWorktree, err := filepath.Rel(gitDir, directory)
+
+	if err != nil {
+		return err
+	}
+
+	cfg.Core.Worktree = filepath.ToSlash(coreWorktree)
+
+	return repo.SetConfig(cfg)
+}
+
+// inits and checks out the commit recorded for
+// every submodule, recursively
+func updateSubmodules(worktree *git.Worktree, directory string, progress *GitProgress, depth int) error {
+	return updateSubmodulesRecursive(worktree, directory, path.Join(directory, ".git"), progress, depth, 0)
+}
+
+func updateSubmodulesRecursive(
+	worktree *git.Worktree,
+	directory string,
+	gitDir string,
+	progress *GitProgress,
+	depth int,
+	level int,
+) error {
+	if level > int(git.DefaultSubmoduleRecursionDepth) {
+		return nil
+	}
+
+	submodules, err := worktree.Submodules()
+
+	if err != nil {
+		return err
+	}
+
+	for _, submodule := range submodules {
+		url := submodule.Config().URL
+		submoduleDirectory := path.Join(directory, submodule.Config().Path)
+		submoduleGitDir := path.Join(gitDir, "modules", submodule.Config().Name)
+
+		if progress != nil {
+			progress.Write([]byte("Updating submodule " + submodule.Config().Path))
+		}
+
+		updateOptions := &git.SubmoduleUpdateOptions{
+			Init:  true,
+			Auth:  checkForGitAuth(url),
+			Depth: depth,
+		}
+
+		err = submodule.Update(updateOptions)
+
+		if err != nil && strings.HasPrefix(err.Error(), "authentication required") {
+			if requestGitAuthentication(url) {
+				updateOptions.Auth = checkForGitAuth(url)
+				err = submodule.Update(updateOptions)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		submoduleRepo, err := submodule.Repository()
+
+		if err != nil {
+			return err
+		}
+
+		submoduleWorktree, err := submoduleRepo.Worktree()
+
+		if err != nil {
+			return err
+		}
+
+		err = fixSubmodulePaths(submoduleRepo, submoduleWorktree, submoduleDirectory, submoduleGitDir)
+
+		if err != nil {
+			return err
+		}
+
+		err = updateSubmodulesRecursive(submoduleWorktree, submoduleDirectory, submoduleGitDir, progress, depth, level+1)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type SubmoduleStatus struct {
+	Path string
+	Url  string
+	// commit recorded in the parent repository
+	Expected string
+	// commit checked out, empty when not initialized
+	Current string
+	// no changes in the submodule worktree
+	Clean bool
+}
+
+func submodulesStatus(worktree *git.Worktree) ([]SubmoduleStatus, error) {
+	submodules, err := worktree.Submodules()
+
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []SubmoduleStatus{}
+
+	for _, submodule := range submodules {
+		status, err := submodule.Status()
+
+		if err != nil {
+			return nil, err
+		}
+
+		submoduleStatus := SubmoduleStatus{
+			Path:     status.Path,
+			Url:      submodule.Config().URL,
+			Expected: status.Expected.String(),
+			Clean:    true,
+		}
+
+		if !status.Current.IsZero() {
+			submoduleStatus.Current = status.Current.String()
+
+			submoduleRepo, err := submodule.Repository()
+			if err != nil {
+				return nil, err
+			}
+
+			submoduleWorktree, err := getWorktree(submoduleRepo)
+			if err != nil {
+				return nil, err
+			}
+
+			submoduleWorktreeStatus, err := worktreeStatus(submoduleRepo, submoduleWorktree)
+			if err != nil {
+				return nil, err
+			}
+
+			submoduleStatus.Clean = submoduleWorktreeStatus.IsClean()
+		}
+
+		statuses = append(statuses, submoduleStatus)
+	}
+
+	return statuses, nil
+}
+
+func Submodules(directory string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	statuses, err := submodulesStatus(worktree)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	wg.Wait()
+
+	data := []byte{}
+
+	// [path, url, expected, current, clean, path, url, expected, current, clean, ...]
+	for _, s := range statuses {
+		data = append(data, serialize.SerializeString(s.Path)...)
+		data = append(data, serialize.SerializeString(s.Url)...)
+		data = append(data, serialize.SerializeString(s.Expected)...)
+		data = append(data, serialize.SerializeString(s.Current)...)
+		data = append(data, serialize.SerializeBoolean(s.Clean)...)
+	}
+
+	return data
+}
+
+func SubmodulesUpdate(directory string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	worktree, err := getWorktree(repo)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	err = updateSubmodules(worktree, directory, nil, 0)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	wg.Wait()
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package git
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	serialize "fullstackedorg/fullstacked/src/serialize"
+)
+
+type Tag struct {
+	Name string
+	// commit the tag points to
+	Hash      string
+	Annotated bool
+	Message   string
+}
+
+func getTags(repo *git.Repository) ([]Tag, error) {
+	refs, err := repo.Tags()
+
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []Tag{}
+
+	err = refs.ForEach(func(r *plumbing.Reference) error {
+		tag := Tag{
+			Name: r.Name().Short(),
+			Hash: r.Hash().String(),
+		}
+
+		// annotated tags point to a tag object
+		tagObject, err := repo.TagObject(r.Hash())
+		if err == nil {
+			tag.Annotated = true
+			tag.Message = strings.TrimSuffix(tagObject.Message, "\n")
+
+			commit, err := tagObject.Commit()
+			if err == nil {
+				tag.Hash = commit.Hash.String()
+			} else {
+				tag.Hash = tagObject.Target.String()
+			}
+		}
+
+		tags = append(tags, tag)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(tags, func(a, b Tag) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return tags, nil
+}
+
+func Tags(directory string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	tags, err := getTags(repo)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	wg.Wait()
+
+	tagsSerialized := []byte{}
+
+	for _, t := range tags {
+		tagsSerialized = append(tagsSerialized, serialize.SerializeString(t.Name)...)
+		tagsSerialized = append(tagsSerialized, serialize.SerializeString(t.Hash)...)
+		tagsSerialized = append(tagsSerialized, serialize.SerializeBoolean(t.Annotated)...)
+		tagsSerialized = append(tagsSerialized, serialize.SerializeString(t.Message)...)
+	}
+
+	return tagsSerialized
+}
+
+// empty ref tags HEAD,
+// a message makes an annotated tag
+func TagCreate(directory string, name string, ref string, message string, authorName string, authorEmail string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	hash, err := resolveRef(repo, ref)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	var opts *git.CreateTagOptions = nil
+	if message != "" {
+		key, err := signKey()
+
+		if err != nil {
+			return serialize.SerializeString(errorFmt(err))
+		}
+
+		tagger := signature(repo, authorName, authorEmail)
+		opts = &git.CreateTagOptions{
+			Tagger:  &tagger,
+			Message: message,
+			SignKey: key,
+		}
+	}
+
+	_, err = repo.CreateTag(name, *hash, opts)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+// only deletes the local tag
+func TagDelete(directory string, name string) []byte {
+	wg := sync.WaitGroup{}
+
+	repo, err := getRepo(directory, &wg)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	err = repo.DeleteTag(name)
+
+	if err != nil {
+		return serialize.SerializeString(errorFmt(err))
+	}
+
+	wg.Wait()
+
+	return nil
+}
@@ -59,26 +59,76 @@ const (
 
 	PACKAGE_INSTALL       = 60
 	PACKAGE_INSTALL_QUICK = 61
+	PACKAGE_UNINSTALL     = 62
+	PACKAGE_UPDATE        = 63
+	PACKAGE_CACHE_PRUNE   = 64
 
 	FULLSTACKED_MODULES_FILE = 65
 	FULLSTACKED_MODULES_LIST = 66
 
-	GIT_CLONE         = 70
-	GIT_HEAD          = 71
-	GIT_STATUS        = 72
-	GIT_PULL          = 73
-	GIT_RESTORE       = 74
-	GIT_CHECKOUT      = 75
-	GIT_FETCH         = 76
-	GIT_COMMIT        = 77
-	GIT_BRANCHES      = 78
-	GIT_PUSH          = 79
-	GIT_BRANCH_DELETE = 80
-	GIT_AUTH_RESPONSE = 81
-	GIT_HAS_GIT       = 82
-	GIT_REMOTE_URL    = 83
+	GIT_CLONE          = 70
+	GIT_HEAD           = 71
+	GIT_STATUS         = 72
+	GIT_PULL           = 73
+	GIT_RESTORE        = 74
+	GIT_CHECKOUT       = 75
+	GIT_FETCH          = 76
+	GIT_COMMIT         = 77
+	GIT_BRANCHES       = 78
+	GIT_PUSH           = 79
+	GIT_BRANCH_DELETE  = 80
+	GIT_AUTH_RESPONSE  = 81
+	GIT_HAS_GIT        = 82
+	GIT_REMOTE_URL     = 83
+	GIT_LOG            = 84
+	GIT_DIFF           = 85
+	GIT_DIFF_COMMITS   = 86
+	GIT_ADD            = 87
+	GIT_RESET          = 88
+	GIT_MERGE_ABORT    = 89
+	GIT_MERGE_CONTINUE = 90
+	GIT_STASH_PUSH     = 91
+	GIT_STASH_LIST     = 92
+	GIT_STASH_APPLY    = 93
+	GIT_STASH_DROP     = 94
+	GIT_TAGS           = 95
+	GIT_TAG_CREATE     = 96
+	GIT_TAG_DELETE     = 97
+	GIT_BLAME          = 98
 
 	OPEN = 100
+
+	GIT_REMOTES            = 110
+	GIT_REMOTE_ADD         = 111
+	GIT_REMOTE_REMOVE      = 112
+	GIT_REMOTE_RENAME      = 113
+	GIT_SET_UPSTREAM       = 114
+	GIT_SSH_KEY_GENERATE   = 115
+	GIT_SSH_PUBLIC_KEY     = 116
+	GIT_HOST_KEY_RESPONSE  = 117
+	GIT_SUBMODULES         = 118
+	GIT_SUBMODULES_UPDATE  = 119
+	GIT_GPG_KEY_GENERATE   = 120
+	GIT_GPG_KEY_IMPORT     = 121
+	GIT_GPG_KEY_DELETE     = 122
+	GIT_GPG_PUBLIC_KEY     = 123
+	GIT_GPG_TRUST_KEY      = 124
+	GIT_CREDENTIALS        = 125
+	GIT_CREDENTIAL_SAVE    = 126
+	GIT_CREDENTIAL_DELETE  = 127
+	GIT_CHERRY_PICK        = 128
+	GIT_REBASE             = 129
+	GIT_SEQUENCER_CONTINUE = 130
+	GIT_SEQUENCER_ABORT    = 131
+	GIT_RESET_REF          = 132
+	GIT_REVERT             = 133
+	GIT_GPG_KEY_UNLOCK     = 134
+	GIT_SERVER_START       = 135
+	GIT_SERVER_STATUS      = 136
+	GIT_SERVER_STOP        = 137
+	GIT_LFS_PULL           = 138
+	GIT_STATUS_STREAM      = 139
+	GIT_CREDENTIALS_RESET  = 140
 )
 
 var EDITOR_ONLY = []int{
@@ -91,6 +141,9 @@ var EDITOR_ONLY = []int{
 
 	PACKAGE_INSTALL,
 	// PACKAGE_INSTALL_QUICK,
+	PACKAGE_UNINSTALL,
+	PACKAGE_UPDATE,
+	PACKAGE_CACHE_PRUNE,
 
 	FULLSTACKED_MODULES_FILE,
 	FULLSTACKED_MODULES_LIST,
@@ -109,6 +162,52 @@ var EDITOR_ONLY = []int{
 	GIT_AUTH_RESPONSE,
 	// GIT_HAS_GIT,
 	// GIT_REMOTE_URL,
+	GIT_LOG,
+	GIT_DIFF,
+	GIT_DIFF_COMMITS,
+	GIT_ADD,
+	GIT_RESET,
+	GIT_MERGE_ABORT,
+	GIT_MERGE_CONTINUE,
+	GIT_STASH_PUSH,
+	GIT_STASH_LIST,
+	GIT_STASH_APPLY,
+	GIT_STASH_DROP,
+	GIT_TAGS,
+	GIT_TAG_CREATE,
+	GIT_TAG_DELETE,
+	GIT_REMOTES,
+	GIT_REMOTE_ADD,
+	GIT_REMOTE_REMOVE,
+	GIT_REMOTE_RENAME,
+	GIT_SET_UPSTREAM,
+	GIT_SSH_KEY_GENERATE,
+	GIT_SSH_PUBLIC_KEY,
+	GIT_HOST_KEY_RESPONSE,
+	GIT_SUBMODULES,
+	GIT_SUBMODULES_UPDATE,
+	GIT_GPG_KEY_GENERATE,
+	GIT_GPG_KEY_IMPORT,
+	GIT_GPG_KEY_DELETE,
+	GIT_GPG_PUBLIC_KEY,
+	GIT_GPG_TRUST_KEY,
+	GIT_GPG_KEY_UNLOCK,
+	GIT_CREDENTIALS,
+	GIT_CREDENTIAL_SAVE,
+	GIT_CREDENTIAL_DELETE,
+	GIT_CREDENTIALS_RESET,
+	GIT_CHERRY_PICK,
+	GIT_REBASE,
+	GIT_SEQUENCER_CONTINUE,
+	GIT_SEQUENCER_ABORT,
+	GIT_RESET_REF,
+	GIT_REVERT,
+	GIT_BLAME,
+	GIT_SERVER_START,
+	GIT_SERVER_STATUS,
+	GIT_SERVER_STOP,
+	GIT_LFS_PULL,
+	GIT_STATUS_STREAM,
 
 	OPEN,
 }
@@ -231,15 +330,44 @@ func Call(payload []byte) []byte {
 		if isEditor {
 			projectDirectory = path.Join(setup.Directories.Root, args[0].(string))
 			installationId = args[1].(float64)
+			args = args[2:]
 		} else {
 			installationId = args[0].(float64)
+			args = args[1:]
 		}
 
-		go packages.InstallQuick(projectId, installationId, projectDirectory)
+		offline := len(args) > 0 && args[0].(bool)
+
+		go packages.InstallQuick(projectId, installationId, projectDirectory, offline)
+	case method == PACKAGE_UNINSTALL:
+		projectDirectory := setup.Directories.Root + "/" + args[0].(string)
+		installationId := args[1].(float64)
+		packagesToUninstall := []string{}
+		for _, p := range args[2:] {
+			packagesToUninstall = append(packagesToUninstall, p.(string))
+		}
+		go packages.Uninstall(installationId, projectDirectory, packagesToUninstall)
+	case method == PACKAGE_UPDATE:
+		projectDirectory := setup.Directories.Root + "/" + args[0].(string)
+		installationId := args[1].(float64)
+		packagesToUpdate := []string{}
+		for _, p := range args[3:] {
+			packagesToUpdate = append(packagesToUpdate, p.(string))
+		}
+		go packages.Update(installationId, projectDirectory, packagesToUpdate, args[2].(bool))
+	case method == PACKAGE_CACHE_PRUNE:
+		maxSize := packages.CacheMaxSize
+		if len(args) > 0 {
+			maxSize = int64(args[0].(float64))
+		}
+		removed, size := packages.PruneCache(maxSize)
+		bytes := serialize.SerializeNumber(float64(removed))
+		bytes = append(bytes, serialize.SerializeNumber(float64(size))...)
+		return bytes
 	case method == OPEN:
 		setup.Callback("", "open", args[0].(string))
 		return nil
-	case method >= 70 && method <= 83:
+	case method >= 70 && method <= 98, method >= 110 && method <= 140:
 		return gitSwitch(isEditor, projectId, method, args)
 	case method == FULLSTACKED_MODULES_FILE:
 		filePath := args[0].(string)
@@ -323,20 +451,44 @@ func gitSwitch(isEditor bool, projectId string, method int, args []any) []byte {
 
 	// most git methods uses the directory as first argument
 	if isEditor && len(args) > 0 {
-		directory = path.Join(setup.Directories.Root, args[0].(string))
+		if project, ok := args[0].(string); ok {
+			directory = path.Join(setup.Directories.Root, project)
+		}
 	}
 
 	switch method {
 	case GIT_CLONE:
-		go git.Clone(directory, args[1].(string))
+		options := git.CloneOptions{}
+		if len(args) > 4 {
+			options.Depth = int(args[2].(float64))
+			options.SingleBranch = args[3].(bool)
+			options.Ref = args[4].(string)
+		}
+		go git.Clone(directory, args[1].(string), options)
 	case GIT_HEAD:
 		return git.HeadSerialized(directory)
 	case GIT_STATUS:
 		return git.Status(directory)
 	case GIT_PULL:
-		go git.Pull(directory, isEditor, projectId)
+		authorName := ""
+		authorEmail := ""
+		if len(args) > 2 {
+			authorName = args[1].(string)
+			authorEmail = args[2].(string)
+		}
+		autoStash := len(args) > 3 && args[3].(bool)
+		remote := ""
+		if len(args) > 4 {
+			remote = args[4].(string)
+		}
+		go git.Pull(directory, isEditor, projectId, authorName, authorEmail, autoStash, remote)
 	case GIT_PUSH:
-		go git.Push(directory)
+		tags := len(args) > 1 && args[1].(bool)
+		remote := ""
+		if len(args) > 2 {
+			remote = args[2].(string)
+		}
+		go git.Push(directory, tags, remote)
 	case GIT_RESTORE:
 		files := []string{}
 		for _, file := range args[1:] {
@@ -346,11 +498,20 @@ func gitSwitch(isEditor bool, projectId string, method int, args []any) []byte {
 	case GIT_CHECKOUT:
 		return git.Checkout(directory, args[1].(string), args[2].(bool))
 	case GIT_FETCH:
-		return git.Fetch(directory)
+		remote := ""
+		if len(args) > 1 {
+			remote = args[1].(string)
+		}
+		return git.Fetch(directory, remote)
 	case GIT_COMMIT:
-		return git.Commit(directory, args[1].(string), args[2].(string), args[3].(string))
+		stagedOnly := len(args) > 4 && args[4].(bool)
+		return git.Commit(directory, args[1].(string), args[2].(string), args[3].(string), stagedOnly)
 	case GIT_BRANCHES:
-		return git.Branches(directory)
+		remote := ""
+		if len(args) > 1 {
+			remote = args[1].(string)
+		}
+		return git.Branches(directory, remote)
 	case GIT_BRANCH_DELETE:
 		return git.BranchDelete(directory, args[1].(string))
 	case GIT_AUTH_RESPONSE:
@@ -358,7 +519,130 @@ func gitSwitch(isEditor bool, projectId string, method int, args []any) []byte {
 	case GIT_HAS_GIT:
 		return serialize.SerializeBoolean(git.HasGit(directory))
 	case GIT_REMOTE_URL:
-		return serialize.SerializeString(git.RemoteURL(directory))
+		remote := ""
+		if len(args) > 1 {
+			remote = args[1].(string)
+		}
+		return serialize.SerializeString(git.RemoteURL(directory, remote))
+	case GIT_LOG:
+		return git.LogSerialized(directory, args[1].(string), int(args[2].(float64)), int(args[3].(float64)), args[4].(string))
+	case GIT_DIFF:
+		files := []string{}
+		for _, file := range args[2:] {
+			files = append(files, file.(string))
+		}
+		return git.DiffSerialized(directory, args[1].(bool), files)
+	case GIT_DIFF_COMMITS:
+		files := []string{}
+		for _, file := range args[3:] {
+			files = append(files, file.(string))
+		}
+		return git.DiffCommitsSerialized(directory, args[1].(string), args[2].(string), files)
+	case GIT_ADD:
+		files := []string{}
+		for _, file := range args[1:] {
+			files = append(files, file.(string))
+		}
+		return git.Add(directory, files)
+	case GIT_RESET:
+		files := []string{}
+		for _, file := range args[1:] {
+			files = append(files, file.(string))
+		}
+		return git.Unstage(directory, files)
+	case GIT_MERGE_ABORT:
+		return git.MergeAbort(directory)
+	case GIT_MERGE_CONTINUE:
+		return git.MergeContinue(directory, args[1].(string), args[2].(string))
+	case GIT_STASH_PUSH:
+		return git.StashPush(directory, args[1].(string), args[2].(string), args[3].(string))
+	case GIT_STASH_LIST:
+		return git.StashList(directory)
+	case GIT_STASH_APPLY:
+		return git.StashApply(directory, int(args[1].(float64)), args[2].(bool))
+	case GIT_STASH_DROP:
+		return git.StashDrop(directory, int(args[1].(float64)))
+	case GIT_TAGS:
+		return git.Tags(directory)
+	case GIT_TAG_CREATE:
+		return git.TagCreate(directory, args[1].(string), args[2].(string), args[3].(string), args[4].(string), args[5].(string))
+	case GIT_TAG_DELETE:
+		return git.TagDelete(directory, args[1].(string))
+	case GIT_REMOTES:
+		return git.Remotes(directory)
+	case GIT_REMOTE_ADD:
+		return git.RemoteAdd(directory, args[1].(string), args[2].(string))
+	case GIT_REMOTE_REMOVE:
+		return git.RemoteRemove(directory, args[1].(string))
+	case GIT_REMOTE_RENAME:
+		return git.RemoteRename(directory, args[1].(string), args[2].(string))
+	case GIT_SET_UPSTREAM:
+		return git.SetUpstream(directory, args[1].(string), args[2].(string), args[3].(string))
+	case GIT_SSH_KEY_GENERATE:
+		return git.SshKeyGenerate(args[0].(string))
+	case GIT_SSH_PUBLIC_KEY:
+		return git.SshPublicKey()
+	case GIT_HOST_KEY_RESPONSE:
+		git.HostKeyResponse(args[0].(string), args[1].(bool))
+	case GIT_SUBMODULES:
+		return git.Submodules(directory)
+	case GIT_SUBMODULES_UPDATE:
+		return git.SubmodulesUpdate(directory)
+	case GIT_GPG_KEY_GENERATE:
+		return git.GpgKeyGenerate(args[0].(string), args[1].(string), args[2].(string))
+	case GIT_GPG_KEY_IMPORT:
+		return git.GpgKeyImport(args[0].(string), args[1].(string))
+	case GIT_GPG_KEY_DELETE:
+		return git.GpgKeyDelete()
+	case GIT_GPG_PUBLIC_KEY:
+		return git.GpgPublicKey()
+	case GIT_GPG_TRUST_KEY:
+		return git.GpgTrustKey(args[0].(string))
+	case GIT_GPG_KEY_UNLOCK:
+		return git.GpgKeyUnlock(args[0].(string))
+	case GIT_CREDENTIALS:
+		return git.CredentialsList()
+	case GIT_CREDENTIAL_SAVE:
+		return git.CredentialSave(git.GitCredential{
+			Host:     args[0].(string),
+			Path:     args[1].(string),
+			Username: args[2].(string),
+			Password: args[3].(string),
+			Token:    args[4].(string),
+			Expires:  int64(args[5].(float64)),
+		})
+	case GIT_CREDENTIAL_DELETE:
+		return git.CredentialDelete(args[0].(string), args[1].(string))
+	case GIT_CREDENTIALS_RESET:
+		return git.CredentialsReset()
+	case GIT_CHERRY_PICK:
+		hashes := []string{}
+		for _, hash := range args[3:] {
+			hashes = append(hashes, hash.(string))
+		}
+		go git.CherryPick(directory, projectId, hashes, args[1].(string), args[2].(string))
+	case GIT_REBASE:
+		go git.Rebase(directory, projectId, args[1].(string), args[2].(string), args[3].(string))
+	case GIT_SEQUENCER_CONTINUE:
+		go git.SequencerContinue(directory, projectId, args[1].(string), args[2].(string))
+	case GIT_SEQUENCER_ABORT:
+		return git.SequencerAbort(directory)
+	case GIT_RESET_REF:
+		return git.Reset(directory, args[1].(string), git.ResetMode(args[2].(string)))
+	case GIT_REVERT:
+		go git.Revert(directory, projectId, args[1].(string), args[2].(string), args[3].(string))
+	case GIT_BLAME:
+		return git.Blame(directory, args[1].(string), args[2].(string))
+	case GIT_SERVER_START:
+		return git.ServerStart(int(args[0].(float64)), args[1].(bool), args[2].(bool))
+	case GIT_SERVER_STATUS:
+		return git.ServerStatus()
+	case GIT_SERVER_STOP:
+		return git.ServerStop()
+	case GIT_LFS_PULL:
+		return git.LfsPull(directory)
+	case GIT_STATUS_STREAM:
+		go git.StatusStream(directory, projectId, args[1].(float64))
 	}
 
 	return nil
@@ -0,0 +1,388 @@
+package packages
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	fs "fullstackedorg/fullstacked/src/fs"
+	setup "fullstackedorg/fullstacked/src/setup"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// extracted tarballs shared by all projects,
+// keyed by the sha512 of the tarball
+//
+//	packages-cache/<sha512 hex>/package/...
+//	packages-cache/<sha512 hex>/digest
+//	packages-cache/<sha512 hex>/used
+//
+// files are read-only and hard linked into node_modules
+// when possible, the digest of the extracted files
+// is checked before every link
+var cacheDirectoryName = "packages-cache"
+
+// least recently used entries are pruned above this size
+var CacheMaxSize int64 = 2 << 30
+
+// extractions in progress, left over on crash
+var cacheTmpSuffix = ".tmp"
+var cacheTmpMaxAge = time.Hour
+
+var cacheMutex = sync.Mutex{}
+
+// entries being linked, never pruned
+var cacheInUse = map[string]int{}
+
+// entries that can't be linked, the package is extracted again
+var (
+	errCacheEntryCorrupted = errors.New("packages cache entry corrupted")
+	errCacheEntryPruned    = errors.New("package no longer in cache")
+)
+
+func cacheEntryUnusable(err error) bool {
+	return errors.Is(err, errCacheEntryCorrupted) || errors.Is(err, errCacheEntryPruned)
+}
+
+func cacheDirectory() string {
+	return path.Join(setup.Directories.Config, cacheDirectoryName)
+}
+
+// hex of the sha512 in the integrity,
+// empty when there is none
+func cacheKey(integrity string) string {
+	for _, h := range strings.Fields(integrity) {
+		digest, ok := strings.CutPrefix(h, "sha512-")
+		if !ok {
+			continue
+		}
+		digest, _, _ = strings.Cut(digest, "?")
+
+		sum, err := base64.StdEncoding.DecodeString(digest)
+		if err == nil {
+			return hex.EncodeToString(sum)
+		}
+	}
+
+	return ""
+}
+
+func cacheEntryPackage(key string) string {
+	return path.Join(cacheDirectory(), key, "package")
+}
+
+func cacheEntryDigest(key string) string {
+	return path.Join(cacheDirectory(), key, "digest")
+}
+
+// sha256 of the files paths and contents
+func cacheDigest(directory string) (string, error) {
+	items, err := fs.ReadDir(directory, true, true, nil)
+	if err != nil {
+		return "", err
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Name < items[j].Name
+	})
+
+	h := sha256.New()
+	for _, item := range items {
+		data, err := fs.ReadFile(path.Join(directory, item.Name))
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte(item.Name + "\x00" + strconv.Itoa(len(data)) + "\x00"))
+		h.Write(data)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// an entry without digest is corrupted
+func cacheVerify(key string) error {
+	digest, err := cacheDigest(cacheEntryPackage(key))
+	if err != nil {
+		return err
+	}
+
+	expected, err := fs.ReadFile(cacheEntryDigest(key))
+	if err != nil {
+		return errCacheEntryCorrupted
+	}
+
+	if string(expected) != digest {
+		return errCacheEntryCorrupted
+	}
+
+	return nil
+}
+
+func cacheHas(key string) bool {
+	if key == "" {
+		return false
+	}
+	exists, isFile := fs.Exists(cacheEntryPackage(key))
+	return exists && !isFile
+}
+
+// extracts the verified tarball in the cache
+func (p *Package) cacheTarball(key string, packageDataGZIP []byte) error {
+	p.Progress.Stage = "unpacking"
+	p.Progress.Loaded = 0
+	p.Progress.Total = 0
+	p.notify()
+
+	// get item count
+	gunzipReaderCount, err := gzip.NewReader(bytes.NewReader(packageDataGZIP))
+	if err != nil {
+		return err
+	}
+	defer gunzipReaderCount.Close()
+
+	tarReaderCount := tar.NewReader(gunzipReaderCount)
+	totalItemCount := 0
+	for {
+		_, err := tarReaderCount.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		totalItemCount += 1
+	}
+	p.Progress.Total = totalItemCount
+	p.notify()
+
+	tmpEntry := path.Join(cacheDirectory(), key+"."+strconv.FormatInt(time.Now().UnixNano(), 36)+cacheTmpSuffix)
+	tmpDirectory := path.Join(tmpEntry, "package")
+	fs.Mkdir(tmpDirectory, fileEventOrigin)
+	defer fs.Rmdir(tmpEntry, fileEventOrigin)
+
+	// untar
+	gunzipReader, err := gzip.NewReader(bytes.NewReader(packageDataGZIP))
+	if err != nil {
+		return err
+	}
+	defer gunzipReader.Close()
+
+	tarReader := tar.NewReader(gunzipReader)
+
+	for {
+		header, err := tarReader.Next()
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		// strip 1
+		filePath := strings.Join(strings.Split(header.Name, "/")[1:], "/")
+
+		target := path.Join(tmpDirectory, filePath)
+
+		if !strings.HasPrefix(target, tmpDirectory+"/") {
+			fmt.Println("skipping tarball item outside of package [" + header.Name + "]")
+		} else if header.Typeflag == tar.TypeDir {
+			fs.Mkdir(target, fileEventOrigin)
+		} else if header.Typeflag == tar.TypeReg {
+			dir, _ := path.Split(target)
+			fs.Mkdir(dir, fileEventOrigin)
+			fileData, err := io.ReadAll(tarReader)
+			if err != nil {
+				return err
+			}
+			// duplicate entries, the last one wins
+			if exists, _ := fs.Exists(target); exists {
+				fs.Unlink(target, fileEventOrigin)
+			}
+			// node_modules edits must not reach the cache
+			err = fs.WriteFileMode(target, fileData, 0444, fileEventOrigin)
+			if err != nil {
+				return err
+			}
+		}
+
+		p.Progress.Loaded += 1
+		p.notify()
+	}
+
+	digest, err := cacheDigest(tmpDirectory)
+	if err != nil {
+		return err
+	}
+
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	// extracted concurrently by another installation
+	if cacheHas(key) {
+		return nil
+	}
+
+	entry := path.Join(cacheDirectory(), key)
+	fs.Mkdir(entry, fileEventOrigin)
+	err = fs.WriteFile(cacheEntryDigest(key), []byte(digest), fileEventOrigin)
+	if err != nil {
+		return err
+	}
+	if !fs.Rename(tmpDirectory, cacheEntryPackage(key), fileEventOrigin) {
+		return errors.New("failed to move package to cache")
+	}
+
+	return nil
+}
+
+// a corrupted entry is removed
+// so it can be extracted again
+func (p *Package) installFromCache(key string, directory string) error {
+	cacheMutex.Lock()
+	if !cacheHas(key) {
+		cacheMutex.Unlock()
+		return errCacheEntryPruned
+	}
+	cacheInUse[key] += 1
+	cacheMutex.Unlock()
+
+	defer func() {
+		cacheMutex.Lock()
+		cacheInUse[key] -= 1
+		if cacheInUse[key] == 0 {
+			delete(cacheInUse, key)
+		}
+		cacheMutex.Unlock()
+	}()
+
+	err := cacheVerify(key)
+	if errors.Is(err, errCacheEntryCorrupted) {
+		cacheMutex.Lock()
+		fs.Rmdir(path.Join(cacheDirectory(), key), fileEventOrigin)
+		cacheMutex.Unlock()
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	entryPackage := cacheEntryPackage(key)
+
+	items, err := fs.ReadDir(entryPackage, true, false, nil)
+	if err != nil {
+		return err
+	}
+
+	p.Progress.Stage = "linking"
+	p.Progress.Loaded = 0
+	p.Progress.Total = len(items)
+	p.notify()
+
+	fs.Mkdir(directory, fileEventOrigin)
+	for _, item := range items {
+		target := path.Join(directory, item.Name)
+
+		if item.IsDir {
+			fs.Mkdir(target, fileEventOrigin)
+		} else {
+			dir, _ := path.Split(target)
+			fs.Mkdir(dir, fileEventOrigin)
+			err = fs.Link(path.Join(entryPackage, item.Name), target, fileEventOrigin)
+			if err != nil {
+				return err
+			}
+		}
+
+		p.Progress.Loaded += 1
+	}
+
+	fs.WriteFile(
+		path.Join(cacheDirectory(), key, "used"),
+		[]byte(strconv.FormatInt(time.Now().UnixMilli(), 10)),
+		fileEventOrigin,
+	)
+
+	p.Progress.Stage = "done"
+	p.Progress.Loaded = 1
+	p.Progress.Total = 1
+	p.notify()
+
+	return nil
+}
+
+type cacheEntry struct {
+	key  string
+	size int64
+	used int64
+}
+
+// removes the least recently used entries
+// until the cache is under maxSize.
+// returns the removed entries count and the remaining size
+func PruneCache(maxSize int64) (int, int64) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	items, err := fs.ReadDir(cacheDirectory(), false, false, nil)
+	if err != nil {
+		return 0, 0
+	}
+
+	entries := []cacheEntry{}
+	size := int64(0)
+	for _, item := range items {
+		if !item.IsDir {
+			continue
+		}
+
+		if strings.HasSuffix(item.Name, cacheTmpSuffix) {
+			if time.Since(item.MTime) > cacheTmpMaxAge {
+				fs.Rmdir(path.Join(cacheDirectory(), item.Name), fileEventOrigin)
+			}
+			continue
+		}
+
+		entry := cacheEntry{key: item.Name}
+
+		files, _ := fs.ReadDir(path.Join(cacheDirectory(), item.Name), true, true, nil)
+		for _, f := range files {
+			entry.size += f.Size
+		}
+
+		usedData, _ := fs.ReadFile(path.Join(cacheDirectory(), item.Name, "used"))
+		entry.used, _ = strconv.ParseInt(string(usedData), 10, 64)
+
+		entries = append(entries, entry)
+		size += entry.size
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].used < entries[j].used
+	})
+
+	removed := 0
+	for _, entry := range entries {
+		if size <= maxSize {
+			break
+		}
+
+		if cacheInUse[entry.key] > 0 {
+			continue
+		}
+
+		if fs.Rmdir(path.Join(cacheDirectory(), entry.key), fileEventOrigin) {
+			size -= entry.size
+			removed += 1
+		}
+	}
+
+	return removed, size
+}
@@ -0,0 +1,80 @@
+package packages
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strings"
+)
+
+// strongest first
+var integrityAlgorithms = []string{"sha512", "sha384", "sha256", "sha1"}
+
+func integrityHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case "sha512":
+		return sha512.New()
+	case "sha384":
+		return sha512.New384()
+	case "sha256":
+		return sha256.New()
+	case "sha1":
+		return sha1.New()
+	}
+	return nil
+}
+
+// registries without dist.integrity only give a hex sha1
+func shasumToIntegrity(shasum string) string {
+	sum, err := hex.DecodeString(shasum)
+	if err != nil || len(sum) != sha1.Size {
+		return ""
+	}
+	return "sha1-" + base64.StdEncoding.EncodeToString(sum)
+}
+
+func computeIntegrity(data []byte) string {
+	sum := sha512.Sum512(data)
+	return "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Subresource Integrity string, space separated hashes.
+// only the hashes of the strongest known algorithm are checked,
+// one of them must match
+func verifyIntegrity(data []byte, integrity string) error {
+	hashes := map[string][]string{}
+	for _, h := range strings.Fields(integrity) {
+		algorithm, digest, ok := strings.Cut(h, "-")
+		if !ok {
+			continue
+		}
+		// drop options, sha512-digest?opt
+		digest, _, _ = strings.Cut(digest, "?")
+		hashes[algorithm] = append(hashes[algorithm], digest)
+	}
+
+	for _, algorithm := range integrityAlgorithms {
+		digests, ok := hashes[algorithm]
+		if !ok {
+			continue
+		}
+
+		h := integrityHash(algorithm)
+		h.Write(data)
+		sum := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+		for _, digest := range digests {
+			if digest == sum {
+				return nil
+			}
+		}
+
+		return errors.New("integrity mismatch, expected " + integrity + " got " + algorithm + "-" + sum)
+	}
+
+	return errors.New("unsupported integrity " + integrity)
+}
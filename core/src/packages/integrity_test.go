@@ -0,0 +1,109 @@
+package packages
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func digest(sum []byte) string {
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
+func TestVerifyIntegrity(t *testing.T) {
+	data := []byte("module.exports = 42")
+	other := []byte("module.exports = 43")
+
+	sha512Sum := sha512.Sum512(data)
+	sha384Sum := sha512.Sum384(data)
+	sha256Sum := sha256.Sum256(data)
+	sha1Sum := sha1.Sum(data)
+	otherSha512Sum := sha512.Sum512(other)
+	otherSha1Sum := sha1.Sum(other)
+
+	sha512Data := "sha512-" + digest(sha512Sum[:])
+	sha384Data := "sha384-" + digest(sha384Sum[:])
+	sha256Data := "sha256-" + digest(sha256Sum[:])
+	sha1Data := "sha1-" + digest(sha1Sum[:])
+	sha512Other := "sha512-" + digest(otherSha512Sum[:])
+	sha1Other := "sha1-" + digest(otherSha1Sum[:])
+
+	tests := []struct {
+		name      string
+		integrity string
+		// substring of the error, empty when valid
+		err string
+	}{
+		{"sha512", sha512Data, ""},
+		{"sha384", sha384Data, ""},
+		{"sha256", sha256Data, ""},
+		{"sha1", sha1Data, ""},
+		{"computed", computeIntegrity(data), ""},
+		{"options", sha512Data + "?foo", ""},
+		{"options on every hash", sha1Data + "?a " + sha512Data + "?b=c", ""},
+		{"extra whitespace", "  " + sha512Data + "\n\t", ""},
+		{"multi hash", sha1Data + " " + sha512Data, ""},
+		{"multi hash same algorithm", sha512Other + " " + sha512Data, ""},
+		{"strongest checked only", sha1Data + " " + sha512Other, "integrity mismatch"},
+		{"weaker mismatch ignored", sha1Other + " " + sha256Data, ""},
+		{"unknown algorithm ignored", "md5-AAAA " + sha256Data, ""},
+		{"mismatch", sha512Other, "integrity mismatch"},
+		{"mismatch with options", sha512Other + "?foo", "integrity mismatch"},
+		{"sha1 mismatch", sha1Other, "integrity mismatch"},
+		{"unsupported algorithm", "md5-1B2M2Y8AsgTpgAmY7PhCfg==", "unsupported integrity"},
+		{"no algorithm", digest(sha512Sum[:]), "unsupported integrity"},
+		{"uppercase algorithm", "SHA512-" + digest(sha512Sum[:]), "unsupported integrity"},
+		{"empty", "", "unsupported integrity"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifyIntegrity(data, test.integrity)
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("expected valid, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestShasumToIntegrity(t *testing.T) {
+	data := []byte("module.exports = 42")
+	sum := sha1.Sum(data)
+	shasum := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name     string
+		shasum   string
+		expected string
+	}{
+		{"valid", shasum, "sha1-" + digest(sum[:])},
+		{"uppercase", strings.ToUpper(shasum), "sha1-" + digest(sum[:])},
+		{"empty", "", ""},
+		{"not hex", strings.Repeat("z", 40), ""},
+		{"too short", shasum[:38], ""},
+		{"sha256 length", strings.Repeat("ab", sha256.Size), ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			integrity := shasumToIntegrity(test.shasum)
+			if integrity != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, integrity)
+			}
+		})
+	}
+
+	if err := verifyIntegrity(data, shasumToIntegrity(shasum)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,11 +2,11 @@ package packages
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	fs "fullstackedorg/fullstacked/src/fs"
 	"fullstackedorg/fullstacked/src/git"
 	setup "fullstackedorg/fullstacked/src/setup"
-	"net/http"
 	"net/url"
 	"path"
 	"slices"
@@ -21,14 +21,89 @@ import (
 var fileEventOrigin = "packages"
 
 type Installation struct {
-	Id                     float64           `json:"id"`
-	PackagesInstalledCount float64           `json:"packagesInstalledCount"`
-	Duration               float64           `json:"duration"`
-	ProjectId              string            `json:"-"`
-	Packages               []*Package        `json:"-"`
-	LocalPackages          []PackageLockJSON `json:"-"`
-	BaseDirectory          string            `json:"-"`
-	Quick                  bool              `json:"-"`
+	Id                     float64             `json:"id"`
+	PackagesInstalledCount float64             `json:"packagesInstalledCount"`
+	PackagesRemovedCount   float64             `json:"packagesRemovedCount"`
+	Duration               float64             `json:"duration"`
+	Status                 string              `json:"status"`
+	Errors                 []InstallationError `json:"errors,omitempty"`
+	Warnings               []InstallationError `json:"warnings,omitempty"`
+	Missing                []string            `json:"missing,omitempty"`
+	ProjectId              string              `json:"-"`
+	Packages               []*Package          `json:"-"`
+	LocalPackages          []PackageLockJSON   `json:"-"`
+	BaseDirectory          string              `json:"-"`
+	NpmConfig              *NpmConfig          `json:"-"`
+	Quick                  bool                `json:"-"`
+	Offline                bool                `json:"-"`
+	// removed from optionalDependencies too
+	Uninstalled []string `json:"-"`
+	mutex       sync.Mutex
+}
+
+const (
+	INSTALLATION_SUCCESS = "success"
+	INSTALLATION_FAILURE = "failure"
+)
+
+// a package that could not be resolved or installed,
+// or a warning for optional packages and peers conflicts.
+// version is the requested range when resolving
+type InstallationError struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Location string `json:"location,omitempty"`
+	Stage    string `json:"stage"`
+	Message  string `json:"message"`
+}
+
+func (i *Installation) addError(name string, version string, location string, stage string, err error) {
+	fmt.Println(name + "@" + version + ": " + err.Error())
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if errors.Is(err, errNotAvailableOffline) {
+		i.Missing = appendIfContainsNot(i.Missing, name+"@"+version)
+		return
+	}
+
+	i.Errors = append(i.Errors, InstallationError{
+		Name:     name,
+		Version:  version,
+		Location: location,
+		Stage:    stage,
+		Message:  err.Error(),
+	})
+}
+
+func (i *Installation) addWarning(name string, version string, location string, stage string, err error) {
+	fmt.Println("warning " + name + "@" + version + ": " + err.Error())
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.Warnings = append(i.Warnings, InstallationError{
+		Name:     name,
+		Version:  version,
+		Location: location,
+		Stage:    stage,
+		Message:  err.Error(),
+	})
+}
+
+func (i *Installation) failed() bool {
+	return len(i.Errors) > 0 || len(i.Missing) > 0
+}
+
+func (i *Installation) end(start int64) {
+	i.Status = INSTALLATION_SUCCESS
+	if i.failed() {
+		i.Status = INSTALLATION_FAILURE
+	}
+
+	i.Duration = float64(time.Now().UnixMilli() - start)
+	i.notify()
 }
 
 func (i *Installation) notify() {
@@ -74,9 +149,11 @@ func ParsePackageName(name string) (string, string) {
 
 type npmPackageInfoVersion struct {
 	Dist struct {
-		Tarball string `json:"tarball"`
+		Tarball   string `json:"tarball"`
+		Integrity string `json:"integrity"`
+		Shasum    string `json:"shasum"`
 	} `json:"dist"`
-	Dependencies map[string]string `json:"dependencies"`
+	PackageDependenciesJSON
 }
 
 type npmPackageInfo struct {
@@ -84,31 +161,27 @@ type npmPackageInfo struct {
 	Versions map[string]npmPackageInfoVersion `json:"versions"`
 }
 
-func findAvailableVersion(name string, versionRequested string) *semver.Version {
-	// get available versions and tag on npmjs
-	npmVersions, err := http.Get("https://registry.npmjs.org/" + name)
+// available versions and tags on the registry
+func getPackageInfo(config *NpmConfig, name string) (*npmPackageInfo, error) {
+	npmVersions, err := config.get(config.packageUrl(name))
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		return nil, err
 	}
 	defer npmVersions.Body.Close()
 
 	npmVersionsJSON := &npmPackageInfo{}
 	err = json.NewDecoder(npmVersions.Body).Decode(npmVersionsJSON)
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		return nil, err
 	}
 
-	// check in tags if versioon where looking for is there
-	// ie package@beta
-	if npmVersionsJSON.Tags[versionRequested] != "" {
-		versionRequested = npmVersionsJSON.Tags[versionRequested]
-	}
+	return npmVersionsJSON, nil
+}
 
-	constraints, _ := semver.NewConstraint(versionRequested)
+// highest first
+func (info *npmPackageInfo) availableVersions() []*semver.Version {
 	availableVersions := []*semver.Version{}
-	for v := range npmVersionsJSON.Versions {
+	for v := range info.Versions {
 		version, err := semver.NewVersion(v)
 		if err == nil {
 			availableVersions = append(availableVersions, version)
@@ -118,20 +191,38 @@ func findAvailableVersion(name string, versionRequested string) *semver.Version
 	vc := semver.Collection(availableVersions)
 	sort.Sort(sort.Reverse(vc))
 
+	return availableVersions
+}
+
+func findAvailableVersion(config *NpmConfig, name string, versionRequested string) (*semver.Version, error) {
+	npmVersionsJSON, err := getPackageInfo(config, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// check in tags if versioon where looking for is there
+	// ie package@beta
+	if npmVersionsJSON.Tags[versionRequested] != "" {
+		versionRequested = npmVersionsJSON.Tags[versionRequested]
+	}
+
+	constraints, _ := semver.NewConstraint(versionRequested)
+	availableVersions := npmVersionsJSON.availableVersions()
+
 	if constraints != nil {
 		for _, v := range availableVersions {
 			if constraints.Check(v) {
-				return v
+				return v, nil
 			}
 		}
 	}
 
 	// if no constraint works, use latest
 	if len(availableVersions) > 0 {
-		return availableVersions[0]
+		return availableVersions[0], nil
 	}
 
-	return nil
+	return nil, errors.New("no version available")
 }
 
 func (i *Installation) NewPackage(packageName string) Package {
@@ -142,6 +233,14 @@ func (i *Installation) NewPackage(packageName string) Package {
 }
 
 func (i *Installation) NewPackageWithVersionStr(name string, versionStr string) Package {
+	p, err := i.resolvePackage(name, versionStr)
+	if err != nil {
+		i.addError(name, versionStr, "", "resolving", err)
+	}
+	return p
+}
+
+func (i *Installation) resolvePackage(name string, versionStr string) (Package, error) {
 	if versionStr == "" {
 		versionStr = "latest"
 	}
@@ -149,16 +248,18 @@ func (i *Installation) NewPackageWithVersionStr(name string, versionStr string)
 	for _, p := range i.LocalPackages {
 		if p.Name == name && slices.Contains(p.As, versionStr) {
 			v, _ := semver.NewVersion(p.Version)
-			return i.NewPackageFromLock(name, v, []string{versionStr}, p.Git)
+			lp := i.NewPackageFromLock(name, v, []string{versionStr}, p.Git)
+			lp.Integrity = p.Integrity
+			return lp, nil
 		}
 	}
 
 	if strings.Contains(versionStr, "/") {
-		return i.NewPackageFromGit(name, "", pseudoGitUrlToUrl(versionStr), "")
+		return i.NewPackageFromGit(name, "", pseudoGitUrlToUrl(versionStr), ""), nil
 	}
 
-	version := findAvailableVersion(name, versionStr)
-	return i.NewPackageFromLock(name, version, []string{versionStr}, "")
+	version, err := findAvailableVersion(i.NpmConfig, name, versionStr)
+	return i.NewPackageFromLock(name, version, []string{versionStr}, ""), err
 }
 
 func pseudoGitUrlToUrl(pseudoUrl string) *url.URL {
@@ -255,7 +356,10 @@ func (i *Installation) NewPackageFromGit(
 	p := i.NewPackageFromLock(name, nil, []string{pseudoUrl}, refType)
 
 	if versionStr == "" {
-		p.cloneAndCheckoutGitPackageToTmp()
+		err := p.cloneAndCheckoutGitPackageToTmp()
+		if err != nil {
+			i.addError(url.String(), pseudoUrl, "", "cloning", err)
+		}
 	} else {
 		v, err := semver.NewVersion(versionStr)
 		p.Version = v
@@ -293,6 +397,11 @@ func (installation *Installation) getPackageDependencies(
 
 	newPackages := []*Package{}
 	for _, dep := range deps {
+		// failed to resolve, error added
+		if dep.Version == nil {
+			continue
+		}
+
 		seen := false
 
 		mutex.Lock()
@@ -301,6 +410,7 @@ func (installation *Installation) getPackageDependencies(
 				seen = true
 				pp.As = mergeSlices(pp.As, dep.As)
 				pp.Dependants = append(pp.Dependants, p)
+				pp.Optional = pp.Optional && dep.Optional
 				break
 			}
 		}
@@ -381,11 +491,11 @@ func Install(installationId float64, directory string, devDependencies bool, pac
 		PackagesInstalledCount: 0,
 	}
 
+	installation.NpmConfig = loadNpmConfig(directory)
 	installation.loadLocalPackages()
 	directPackages := installation.loadDirectPackages()
 
 	wg := sync.WaitGroup{}
-	mutex := sync.Mutex{}
 
 	newDirectPackages := []Package{}
 	gitPackages := []string{}
@@ -435,28 +545,187 @@ func Install(installationId float64, directory string, devDependencies bool, pac
 		}
 	}
 
-	installation.Packages = directPackages
-	installation.getDependencies(directPackages, &wg, &mutex)
+	installation.resolvePackages(directPackages)
+	installation.installPackages()
+
+	// a failed direct package would be dropped from package.json
+	if !installation.failed() {
+		installation.updatePackageAndLock()
+	}
+
+	installation.end(start)
+}
+
+// resolves the dependencies tree of the direct packages,
+// the ones without version failed to resolve
+func (installation *Installation) resolvePackages(directPackages []*Package) {
+	wg := sync.WaitGroup{}
+	mutex := sync.Mutex{}
+
+	resolved := []*Package{}
+	for _, p := range directPackages {
+		if p.Version != nil {
+			resolved = append(resolved, p)
+		}
+	}
+
+	installation.Packages = resolved
+	installation.getDependencies(resolved, &wg, &mutex)
 
 	wg.Wait()
 
 	installation.untanglePackages()
 
+	installation.resolvePeers()
+}
+
+func (installation *Installation) installPackages() {
+	wg := sync.WaitGroup{}
+	mutex := sync.Mutex{}
+
 	for _, p := range installation.Packages {
 		p.InstallationId = installation.Id
 		wg.Add(1)
-		go p.Install(&installation, "node_modules", &wg, &mutex)
+		go p.Install(installation, "node_modules", &wg, &mutex)
 	}
 
 	wg.Wait()
 
-	installation.updatePackageAndLock()
+	if installation.PackagesInstalledCount > 0 {
+		PruneCache(CacheMaxSize)
+	}
+}
+
+// removes the packages of the previous lock
+// no longer installed at the same location
+func (installation *Installation) pruneOrphans(previousLock []PackageLockJSON) {
+	installed := map[string]bool{}
+
+	var addInstalled func(packages []*Package)
+	addInstalled = func(packages []*Package) {
+		for _, p := range packages {
+			for _, l := range p.Locations {
+				installed[path.Join(l, p.Name)] = true
+			}
+			addInstalled(p.Dependencies)
+		}
+	}
+	addInstalled(installation.Packages)
+
+	for _, lp := range previousLock {
+		for _, l := range lp.Locations {
+			pLocation := path.Join(l, lp.Name)
+
+			if installed[pLocation] {
+				continue
+			}
+
+			// already removed with its dependant
+			pDir := path.Join(installation.BaseDirectory, pLocation)
+			if exists, _ := fs.Exists(pDir); !exists {
+				continue
+			}
+
+			fs.Rmdir(pDir, fileEventOrigin)
+			installation.PackagesRemovedCount += 1
+		}
+	}
+}
+
+// removes the packages from package.json
+// and their dependencies no other package needs
+func Uninstall(installationId float64, directory string, packagesName []string) {
+	start := time.Now().UnixMilli()
+
+	installation := Installation{
+		ProjectId:     "",
+		Id:            installationId,
+		BaseDirectory: directory,
+	}
+
+	installation.NpmConfig = loadNpmConfig(directory)
+	installation.loadLocalPackages()
+	previousLock := installation.LocalPackages
+
+	installation.Uninstalled = packagesName
+
+	directPackages := []*Package{}
+	for _, p := range installation.loadDirectPackages() {
+		if !slices.Contains(packagesName, p.Name) {
+			directPackages = append(directPackages, p)
+		}
+	}
+
+	installation.resolvePackages(directPackages)
+	installation.installPackages()
+
+	// package.json, lock.json and node_modules stay as they were
+	if !installation.failed() {
+		installation.pruneOrphans(previousLock)
+		installation.updatePackageAndLock()
+	}
+
+	installation.end(start)
+}
+
+// resolves the packages again ignoring the lock,
+// within the range of package.json or to their latest version.
+// no packages updates all of them, git packages are kept
+func Update(installationId float64, directory string, packagesName []string, toLatest bool) {
+	start := time.Now().UnixMilli()
+
+	installation := Installation{
+		ProjectId:     "",
+		Id:            installationId,
+		BaseDirectory: directory,
+	}
+
+	updatable := func(name string) bool {
+		return len(packagesName) == 0 || slices.Contains(packagesName, name)
+	}
+
+	installation.NpmConfig = loadNpmConfig(directory)
+	installation.loadLocalPackages()
+	previousLock := installation.LocalPackages
+
+	installation.LocalPackages = []PackageLockJSON{}
+	for _, lp := range previousLock {
+		if lp.Git != "" || !updatable(lp.Name) {
+			installation.LocalPackages = append(installation.LocalPackages, lp)
+		}
+	}
 
-	installation.Duration = float64(time.Now().UnixMilli() - start)
-	installation.notify()
+	directPackages := installation.loadDirectPackages()
+
+	if toLatest {
+		for i, p := range directPackages {
+			// optional ones stay in optionalDependencies
+			if !p.Direct || p.GitRefType != "" || !updatable(p.Name) {
+				continue
+			}
+
+			latest := installation.NewPackageWithVersionStr(p.Name, "latest")
+			latest.Direct = true
+			latest.Dev = p.Dev
+			directPackages[i] = &latest
+		}
+	}
+
+	installation.resolvePackages(directPackages)
+	installation.installPackages()
+
+	// package.json, lock.json and node_modules stay as they were
+	if !installation.failed() {
+		installation.pruneOrphans(previousLock)
+		installation.updatePackageAndLock()
+	}
+
+	installation.end(start)
 }
 
-func InstallQuick(projectId string, installationId float64, directory string) {
+// offline installs only from the cache and the vendored tarballs,
+// the packages found nowhere are reported as missing
+func InstallQuick(projectId string, installationId float64, directory string, offline bool) {
 	start := time.Now().UnixMilli()
 
 	installation := Installation{
@@ -465,17 +734,18 @@ func InstallQuick(projectId string, installationId float64, directory string) {
 		BaseDirectory:          directory,
 		PackagesInstalledCount: 0,
 		Quick:                  true,
+		Offline:                offline,
 	}
 
 	lockFile := path.Join(installation.BaseDirectory, "lock.json")
 	exists, isFile := fs.Exists(lockFile)
 
 	if !exists || !isFile {
-		installation.Duration = float64(time.Now().UnixMilli() - start)
-		installation.notify()
+		installation.end(start)
 		return
 	}
 
+	installation.NpmConfig = loadNpmConfig(directory)
 	installation.loadLocalPackages()
 
 	// shortest path first to avoid cleaning a directory where a sub-dependency was installed
@@ -499,13 +769,18 @@ func InstallQuick(projectId string, installationId float64, directory string) {
 
 	wg.Wait()
 
-	installation.Duration = float64(time.Now().UnixMilli() - start)
-	installation.notify()
+	if installation.PackagesInstalledCount > 0 {
+		PruneCache(CacheMaxSize)
+	}
+
+	installation.end(start)
 }
 
 func installPackageFromLock(installation *Installation, pInfo PackageLockJSON, parentWg *sync.WaitGroup, mutex *sync.Mutex) {
 	v, _ := semver.NewVersion(pInfo.Version)
 	p := installation.NewPackageFromLock(pInfo.Name, v, pInfo.As, pInfo.Git)
+	p.Integrity = pInfo.Integrity
+	p.Optional = pInfo.Optional
 
 	slices.SortFunc(pInfo.Locations, func(a, b string) int {
 		if a < b {
@@ -587,13 +862,33 @@ func (installation *Installation) updatePackageAndLock() {
 		}
 	}
 
+	if len(installation.Uninstalled) > 0 && direct.raw["optionalDependencies"] != nil {
+		optionalDependencies := map[string]json.RawMessage{}
+		json.Unmarshal(direct.raw["optionalDependencies"], &optionalDependencies)
+		for _, name := range installation.Uninstalled {
+			delete(optionalDependencies, name)
+		}
+
+		if len(optionalDependencies) > 0 {
+			data, _ := json.MarshalIndent(optionalDependencies, "", "    ")
+			direct.raw["optionalDependencies"] = json.RawMessage(data)
+		} else {
+			delete(direct.raw, "optionalDependencies")
+		}
+	}
+
+	// the last uninstalled package removes the field
 	if len(direct.Dependencies) > 0 {
 		dependencies, _ := json.MarshalIndent(direct.Dependencies, "", "    ")
 		direct.raw["dependencies"] = json.RawMessage(dependencies)
+	} else {
+		delete(direct.raw, "dependencies")
 	}
 	if len(direct.DevDependencies) > 0 {
 		devDependencies, _ := json.MarshalIndent(direct.DevDependencies, "", "    ")
 		direct.raw["devDependencies"] = json.RawMessage(devDependencies)
+	} else {
+		delete(direct.raw, "devDependencies")
 	}
 
 	jsonData, err := json.MarshalIndent(direct.raw, "", "    ")
@@ -623,6 +918,11 @@ func (installation *Installation) updatePackageAndLock() {
 
 func (lock *PackageLock) addPackagesToLock(packages []*Package) {
 	for _, p := range packages {
+		// failed to install everywhere
+		if len(p.Locations) == 0 {
+			continue
+		}
+
 		for _, pp := range lock.Packages {
 			if pp.Name == p.Name && pp.Version == p.Version.String() {
 				return
@@ -668,6 +968,21 @@ func (installation *Installation) loadDirectPackages() []*Package {
 		}
 	}
 
+	// not direct, package.json keeps them as is
+	for n, v := range packageJson.OptionalDependencies {
+		if _, ok := packageJson.Dependencies[n]; ok {
+			continue
+		}
+
+		p, err := installation.resolvePackage(n, v)
+		if err != nil {
+			installation.addWarning(n, v, "", "optional", err)
+			continue
+		}
+		p.Optional = true
+		directPackages = append(directPackages, &p)
+	}
+
 	if packageJson.DevDependencies != nil {
 		for n, v := range packageJson.DevDependencies {
 			p := installation.NewPackageWithVersionStr(n, v)
@@ -0,0 +1,54 @@
+package packages
+
+import (
+	"errors"
+	fs "fullstackedorg/fullstacked/src/fs"
+	"path"
+	"strings"
+)
+
+var errNotAvailableOffline = errors.New("not available offline")
+
+// tarballs named like npm pack does,
+// @scope/name@1.0.0 > scope-name-1.0.0.tgz
+var defaultVendorDirectory = "vendor"
+
+func vendorTarballName(name string, version string) string {
+	name = strings.TrimPrefix(name, "@")
+	name = strings.ReplaceAll(name, "/", "-")
+	return name + "-" + version + ".tgz"
+}
+
+// no network, from the shared cache
+// or a tarball in the vendor directory
+func (p *Package) installOffline(config *NpmConfig, directory string) error {
+	exists, _ := fs.Exists(directory)
+	if exists {
+		fs.Rmdir(directory, fileEventOrigin)
+	}
+
+	if p.GitRefType != "" {
+		return errNotAvailableOffline
+	}
+
+	// a corrupted or pruned cache entry falls back to the vendor tarball
+	if key := cacheKey(p.Integrity); cacheHas(key) {
+		err := p.installFromCache(key, directory)
+		if !cacheEntryUnusable(err) {
+			return err
+		}
+	}
+
+	tarball := path.Join(config.Vendor, vendorTarballName(p.Name, p.Version.String()))
+	exists, isFile := fs.Exists(tarball)
+	if !exists || !isFile {
+		return errNotAvailableOffline
+	}
+
+	packageDataGZIP, err := fs.ReadFile(tarball)
+	if err != nil {
+		return err
+	}
+
+	return p.installTarball(packageDataGZIP, p.Integrity, directory)
+}
@@ -1,19 +1,17 @@
 package packages
 
 import (
-	"archive/tar"
-	"bytes"
-	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
 	fs "fullstackedorg/fullstacked/src/fs"
 	"fullstackedorg/fullstacked/src/git"
 	setup "fullstackedorg/fullstacked/src/setup"
 	"fullstackedorg/fullstacked/src/utils"
 	"io"
-	"net/http"
 	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,9 +25,19 @@ type Package struct {
 	VersionOriginal string          `json:"-"`
 	GitRefType      git.RefType     `json:"-"`
 	GitTmpDir       string          `json:"-"`
+	Integrity       string          `json:"-"`
 	As              []string        `json:"-"`
 	Direct          bool            `json:"-"`
 	Dev             bool            `json:"-"`
+	// failures are warnings
+	Optional bool `json:"-"`
+	// optional package with a dependency failing to resolve
+	Skipped bool `json:"-"`
+
+	// name: range, installed next to the root packages
+	PeerDependencies map[string]string `json:"-"`
+	// never installed, only checked when present
+	OptionalPeers []string `json:"-"`
 
 	Locations []string `json:"-"`
 
@@ -48,8 +56,19 @@ type Package struct {
 type PackageJSON struct {
 	Name            string            `json:"name"`
 	Version         string            `json:"version"`
-	Dependencies    map[string]string `json:"dependencies"`
 	DevDependencies map[string]string `json:"devDependencies"`
+	PackageDependenciesJSON
+}
+
+// the dependencies fields of package.json
+// and of a version in the registry
+type PackageDependenciesJSON struct {
+	Dependencies         map[string]string `json:"dependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+	PeerDependencies     map[string]string `json:"peerDependencies"`
+	PeerDependenciesMeta map[string]struct {
+		Optional bool `json:"optional"`
+	} `json:"peerDependenciesMeta"`
 }
 
 type PackageLockJSON struct {
@@ -57,6 +76,8 @@ type PackageLockJSON struct {
 	Version   string      `json:"version"`
 	Git       git.RefType `json:"git,omitempty"`
 	As        []string    `json:"as,omitempty"`
+	Integrity string      `json:"integrity,omitempty"`
+	Optional  bool        `json:"optional,omitempty"`
 	Locations []string    `json:"location"`
 }
 
@@ -66,6 +87,8 @@ func (p *Package) toJSON() PackageLockJSON {
 		As:        p.As,
 		Locations: p.Locations,
 		Version:   p.Version.String(),
+		Integrity: p.Integrity,
+		Optional:  p.Optional,
 	}
 	if p.GitRefType != "" {
 		pJson.Git = p.GitRefType
@@ -108,22 +131,34 @@ func (p *Package) getDependencies(i *Installation) []Package {
 
 	deps := p.getDependenciesList(i)
 
-	if deps == nil {
-		return dependencies.packages
+	p.PeerDependencies = deps.PeerDependencies
+	for n, meta := range deps.PeerDependenciesMeta {
+		if meta.Optional {
+			p.OptionalPeers = append(p.OptionalPeers, n)
+		}
 	}
 
 	wg := sync.WaitGroup{}
 	mutex := sync.Mutex{}
-	for n, v := range deps {
+	for n, v := range deps.Dependencies {
+		_, optional := deps.OptionalDependencies[n]
 		wg.Add(1)
-		go NewDependency(i, p, n, v, &dependencies, &wg, &mutex)
+		go NewDependency(i, p, n, v, optional, &dependencies, &wg, &mutex)
+	}
+	// usually also in dependencies
+	for n, v := range deps.OptionalDependencies {
+		if _, ok := deps.Dependencies[n]; ok {
+			continue
+		}
+		wg.Add(1)
+		go NewDependency(i, p, n, v, true, &dependencies, &wg, &mutex)
 	}
 	wg.Wait()
 
 	return dependencies.packages
 }
 
-func (p *Package) getDependenciesList(i *Installation) map[string]string {
+func (p *Package) getDependenciesList(i *Installation) PackageDependenciesJSON {
 	for _, pp := range i.LocalPackages {
 		if pp.Name != p.Name || pp.Version != p.Version.String() {
 			continue
@@ -142,67 +177,94 @@ func (p *Package) getDependenciesList(i *Installation) map[string]string {
 	if p.GitRefType != "" {
 		// not been cloned
 		if p.GitTmpDir == "" {
-			// clone success
-			if p.cloneAndCheckoutGitPackageToTmp() {
-				return p.getDependenciesFromGitPackage()
-			} else {
-				return map[string]string{}
+			err := p.cloneAndCheckoutGitPackageToTmp()
+			if err != nil {
+				if p.Optional {
+					p.Skipped = true
+					i.addWarning(p.Name, p.As[0], "", "optional", err)
+				} else {
+					i.addError(p.Name, p.As[0], "", "cloning", err)
+				}
+				return PackageDependenciesJSON{}
 			}
 		}
 		return p.getDependenciesFromGitPackage()
 	}
 
-	return p.getDependenciesFromRemote()
+	deps, err := p.getDependenciesFromRemote(i.NpmConfig)
+	if err != nil && p.Optional {
+		p.Skipped = true
+		i.addWarning(p.Name, p.Version.String(), "", "optional", err)
+	} else if err != nil {
+		i.addError(p.Name, p.Version.String(), "", "resolving", err)
+	}
+	return deps
 }
 
-func (p *Package) getDependenciesFromLocal(directory string) map[string]string {
+func (p *Package) getDependenciesFromLocal(directory string) PackageDependenciesJSON {
 	packageJsonFile := path.Join(directory, "package.json")
 
 	packageJsonData, err := fs.ReadFile(packageJsonFile)
 
 	if err != nil {
-		return nil
+		return PackageDependenciesJSON{}
 	}
 
 	packageJson := &PackageJSON{}
 	err = json.Unmarshal(packageJsonData, packageJson)
 
 	if err != nil {
-		return nil
+		return PackageDependenciesJSON{}
 	}
 
-	return packageJson.Dependencies
+	return packageJson.PackageDependenciesJSON
 }
 
-func (p *Package) getDependenciesFromRemote() map[string]string {
-	npmPackageInfo, err := http.Get("https://registry.npmjs.org/" + p.Name + "/" + p.Version.String())
+func (p *Package) getDependenciesFromRemote(config *NpmConfig) (PackageDependenciesJSON, error) {
+	npmPackageInfo, err := config.get(config.packageVersionUrl(p.Name, p.Version.String()))
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		return PackageDependenciesJSON{}, err
 	}
 	defer npmPackageInfo.Body.Close()
 
 	npmPackageInfoJSON := &npmPackageInfoVersion{}
 	err = json.NewDecoder(npmPackageInfo.Body).Decode(npmPackageInfoJSON)
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		return PackageDependenciesJSON{}, err
 	}
 
-	return npmPackageInfoJSON.Dependencies
+	return npmPackageInfoJSON.PackageDependenciesJSON, nil
 }
 
+// optional dependencies failing to resolve are skipped.
+// the whole subtree of an optional package is optional,
+// a failure in it skips the optional package
 func NewDependency(
 	installation *Installation,
 	dependant *Package,
 	name string,
 	versionStr string,
+	optional bool,
 	dependencies *Dependencies,
 	wg *sync.WaitGroup,
 	mutex *sync.Mutex,
 ) {
 	defer wg.Done()
-	p := installation.NewPackageWithVersionStr(name, versionStr)
+	p, err := installation.resolvePackage(name, versionStr)
+	if err != nil {
+		if optional {
+			installation.addWarning(name, versionStr, "", "optional", err)
+		} else if dependant.Optional {
+			mutex.Lock()
+			dependant.Skipped = true
+			mutex.Unlock()
+			installation.addWarning(dependant.Name, dependant.Version.String(), "", "optional", errors.New("missing dependency "+name+"@"+versionStr+": "+err.Error()))
+		} else {
+			installation.addError(name, versionStr, "", "resolving", err)
+		}
+		return
+	}
+	p.Optional = optional || dependant.Optional
 	p.Dependants = []*Package{dependant}
 	mutex.Lock()
 	dependencies.packages = append(dependencies.packages, p)
@@ -271,26 +333,54 @@ func (p *Package) Install(
 ) {
 	defer wg.Done()
 
+	// warned when resolving
+	if p.Skipped {
+		return
+	}
+
 	pLocation := path.Join(directory, p.Name)
 	pDir := path.Join(i.BaseDirectory, pLocation)
 
+	mutex.Lock()
 	if p.Locations == nil {
 		p.Locations = []string{}
 	}
 	p.Locations = append(p.Locations, directory)
+	mutex.Unlock()
 
 	if !p.isInstalled(pDir) {
 		mutex.Lock()
 		i.PackagesInstalledCount += 1
 		mutex.Unlock()
 
-		if p.GitRefType != "" {
-			p.installFromGit(pDir)
+		err := (error)(nil)
+		if i.Offline {
+			err = p.installOffline(i.NpmConfig, pDir)
+		} else if p.GitRefType != "" {
+			err = p.installFromGit(pDir)
 		} else {
-			p.installFromRemote(pDir)
+			err = p.installFromRemote(i.NpmConfig, pDir)
+		}
+
+		// not in the lock, its dependencies are not installed
+		if err != nil {
+			fs.Rmdir(pDir, fileEventOrigin)
+
+			mutex.Lock()
+			p.Locations = slices.DeleteFunc(p.Locations, func(l string) bool {
+				return l == directory
+			})
+			mutex.Unlock()
+
+			if p.Optional {
+				i.addWarning(p.Name, p.Version.String(), directory, "optional", err)
+			} else {
+				i.addError(p.Name, p.Version.String(), directory, "installing", err)
+			}
+			return
 		}
 	} else if !i.Quick && (p.GitRefType == git.GIT_BRANCH || p.GitRefType == git.GIT_DEFAULT) {
-		git.Pull(pDir, i.ProjectId == "", i.ProjectId)
+		git.Pull(pDir, i.ProjectId == "", i.ProjectId, "", "", false, "")
 		p.updateNameAndVersionWithPackageJSON(pDir)
 	}
 
@@ -302,33 +392,57 @@ func (p *Package) Install(
 	}
 }
 
-func (p *Package) installFromRemote(directory string) {
+// the tarball is verified against the lock integrity,
+// or the registry one for new packages, before unpacking
+// in the cache
+func (p *Package) installFromRemote(config *NpmConfig, directory string) error {
 	// clean
 	exists, _ := fs.Exists(directory)
 	if exists {
 		fs.Rmdir(directory, fileEventOrigin)
 	}
-	fs.Mkdir(directory, fileEventOrigin)
 
-	npmPackageInfo, err := http.Get("https://registry.npmjs.org/" + p.Name + "/" + p.Version.String())
+	// a corrupted or pruned cache entry is downloaded again
+	if key := cacheKey(p.Integrity); cacheHas(key) {
+		err := p.installFromCache(key, directory)
+		if !cacheEntryUnusable(err) {
+			return err
+		}
+	}
+
+	npmPackageInfo, err := config.get(config.packageVersionUrl(p.Name, p.Version.String()))
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
 	defer npmPackageInfo.Body.Close()
 
 	npmPackageInfoJSON := &npmPackageInfoVersion{}
 	err = json.NewDecoder(npmPackageInfo.Body).Decode(npmPackageInfoJSON)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
+	}
+
+	integrity := p.Integrity
+	if integrity == "" {
+		integrity = npmPackageInfoJSON.Dist.Integrity
+
+		// cached by another project
+		if key := cacheKey(integrity); cacheHas(key) {
+			err := p.installFromCache(key, directory)
+			if !cacheEntryUnusable(err) {
+				p.Integrity = integrity
+				return err
+			}
+		}
+	}
+	if integrity == "" {
+		integrity = shasumToIntegrity(npmPackageInfoJSON.Dist.Shasum)
 	}
 
 	tarballUrl := npmPackageInfoJSON.Dist.Tarball
-	tarballResponse, err := http.Get(tarballUrl)
+	tarballResponse, err := config.get(tarballUrl)
 	if err != nil {
-		fmt.Println("failed to get tarball url")
-		return
+		return errors.New("failed to get tarball: " + err.Error())
 	}
 	defer tarballResponse.Body.Close()
 
@@ -339,69 +453,36 @@ func (p *Package) installFromRemote(directory string) {
 	p.Progress.Total = dlTotal
 	p.notify()
 	dlReader := io.TeeReader(tarballResponse.Body, p)
-	packageDataGZIP, _ := io.ReadAll(dlReader)
-
-	p.Progress.Stage = "unpacking"
-	p.Progress.Loaded = 0
-	p.Progress.Total = 0
-	p.notify()
-
-	// get item count
-	packageDataGZIPBufferCount := bytes.NewBuffer(packageDataGZIP)
-	gunzipReaderCount, _ := gzip.NewReader(packageDataGZIPBufferCount)
-	defer gunzipReaderCount.Close()
-
-	tarReaderCount := tar.NewReader(gunzipReaderCount)
-	totalItemCount := 0
-	for {
-		_, err := tarReaderCount.Next()
-		if err == io.EOF {
-			break
-		}
-		totalItemCount += 1
+	packageDataGZIP, err := io.ReadAll(dlReader)
+	if err != nil {
+		return err
 	}
-	p.Progress.Total = totalItemCount
-	p.notify()
-
-	// untar
-	packageDataGZIPBuffer := bytes.NewBuffer(packageDataGZIP)
-	gunzipReader, _ := gzip.NewReader(packageDataGZIPBuffer)
-	defer gunzipReader.Close()
 
-	tarReader := tar.NewReader(gunzipReader)
-
-	for {
-		header, err := tarReader.Next()
+	return p.installTarball(packageDataGZIP, integrity, directory)
+}
 
-		if err == io.EOF {
-			break
+// empty integrity accepts any tarball
+func (p *Package) installTarball(packageDataGZIP []byte, integrity string, directory string) error {
+	if integrity != "" {
+		err := verifyIntegrity(packageDataGZIP, integrity)
+		if err != nil {
+			return err
 		}
+	}
 
-		if header != nil {
-
-			// strip 1
-			filePath := strings.Join(strings.Split(header.Name, "/")[1:], "/")
-
-			target := path.Join(directory, filePath)
-
-			if header.Typeflag == tar.TypeDir {
-				fs.Mkdir(target, fileEventOrigin)
-			} else if header.Typeflag == tar.TypeReg {
-				dir, _ := path.Split(target)
-				fs.Mkdir(dir, fileEventOrigin)
-				fileData, _ := io.ReadAll(tarReader)
-				fs.WriteFile(target, fileData, fileEventOrigin)
-			}
-		}
+	// the lock keeps a sha512 even if the registry only has a sha1
+	sha512Integrity := computeIntegrity(packageDataGZIP)
+	if p.Integrity == "" {
+		p.Integrity = sha512Integrity
+	}
 
-		p.Progress.Loaded += 1
-		p.notify()
+	key := cacheKey(sha512Integrity)
+	err := p.cacheTarball(key, packageDataGZIP)
+	if err != nil {
+		return err
 	}
 
-	p.Progress.Stage = "done"
-	p.Progress.Loaded = 1
-	p.Progress.Total = 1
-	p.notify()
+	return p.installFromCache(key, directory)
 }
 
 func (p *Package) updateNameAndVersionWithPackageJSON(directory string) {
@@ -434,7 +515,7 @@ func (p *Package) updateNameAndVersionWithPackageJSON(directory string) {
 	p.Version = v
 }
 
-func (p *Package) cloneAndCheckoutGitPackageToTmp() bool {
+func (p *Package) cloneAndCheckoutGitPackageToTmp() error {
 	url := pseudoGitUrlToUrl(p.As[0])
 
 	ref := ""
@@ -445,7 +526,12 @@ func (p *Package) cloneAndCheckoutGitPackageToTmp() bool {
 
 	p.GitTmpDir = path.Join(setup.Directories.Tmp, utils.RandString(6))
 
-	git.Clone(p.GitTmpDir, url.String())
+	git.Clone(p.GitTmpDir, url.String(), git.CloneOptions{})
+	if exists, _ := fs.Exists(p.GitTmpDir); !exists {
+		p.GitTmpDir = ""
+		return errors.New("failed to clone " + url.String())
+	}
+
 	p.GitRefType = git.CheckoutRef(p.GitTmpDir, ref, p.GitRefType)
 
 	p.updateNameAndVersionWithPackageJSON(p.GitTmpDir)
@@ -456,35 +542,33 @@ func (p *Package) cloneAndCheckoutGitPackageToTmp() bool {
 	}
 
 	if p.Name == "" {
-		fmt.Println("missing name in git package")
 		invalidatePackage()
-		return false
+		return errors.New("missing name in git package")
 	} else if p.Version == nil {
-		fmt.Println("missing version in git package")
 		invalidatePackage()
-		return false
+		return errors.New("missing version in git package")
 	}
 
-	return true
+	return nil
 }
 
-func (p *Package) getDependenciesFromGitPackage() map[string]string {
+func (p *Package) getDependenciesFromGitPackage() PackageDependenciesJSON {
 	if p.GitTmpDir == "" {
 		fmt.Println("trying to get git package deps before cloning to tmp")
-		return map[string]string{}
+		return PackageDependenciesJSON{}
 	}
 
 	packageJsonPath := path.Join(p.GitTmpDir, "package.json")
 	exists, isFile := fs.Exists(packageJsonPath)
 	if !exists || !isFile {
 		fmt.Println("no package.json in git package")
-		return map[string]string{}
+		return PackageDependenciesJSON{}
 	}
 
 	packageJsonData, err := fs.ReadFile(packageJsonPath)
 	if err != nil {
 		fmt.Println(err)
-		return map[string]string{}
+		return PackageDependenciesJSON{}
 	}
 
 	packageJson := &PackageJSON{}
@@ -492,21 +576,28 @@ func (p *Package) getDependenciesFromGitPackage() map[string]string {
 
 	if err != nil {
 		fmt.Println(err)
-		return map[string]string{}
+		return PackageDependenciesJSON{}
 	}
 
-	return packageJson.Dependencies
+	return packageJson.PackageDependenciesJSON
 }
 
 // gitUrl: [SCHEME:]hostname[:PORT]:repo/name[#HASH|TAG|BRANCH]
-func (p *Package) installFromGit(directory string) {
+func (p *Package) installFromGit(directory string) error {
 	if p.GitTmpDir == "" {
-		p.cloneAndCheckoutGitPackageToTmp()
+		err := p.cloneAndCheckoutGitPackageToTmp()
+		if err != nil {
+			return err
+		}
 	}
 
 	parentDir := filepath.Dir(directory)
 
 	fs.Mkdir(parentDir, fileEventOrigin)
 	fs.Rmdir(directory, fileEventOrigin)
-	fs.Rename(p.GitTmpDir, directory, fileEventOrigin)
+	if !fs.Rename(p.GitTmpDir, directory, fileEventOrigin) {
+		return errors.New("failed to move git package")
+	}
+
+	return nil
 }
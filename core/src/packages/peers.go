@@ -0,0 +1,213 @@
+package packages
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	semver "github.com/Masterminds/semver/v3"
+)
+
+// visits every package of the tree once
+func (installation *Installation) walkPackages(visit func(p *Package)) {
+	visited := map[*Package]bool{}
+
+	var walk func(packages []*Package)
+	walk = func(packages []*Package) {
+		for _, p := range packages {
+			if visited[p] {
+				continue
+			}
+			visited[p] = true
+			visit(p)
+			walk(p.Dependencies)
+		}
+	}
+
+	walk(installation.Packages)
+}
+
+func (installation *Installation) rootPackage(name string) *Package {
+	for _, p := range installation.Packages {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+type missingPeer struct {
+	versionRanges []string
+	dependants    []*Package
+}
+
+// the highest version satisfying all the ranges, the locked one first.
+// without one, the range allowing the highest version is used
+func (installation *Installation) peerVersion(name string, versionRanges []string) (string, error) {
+	if len(versionRanges) == 1 {
+		return versionRanges[0], nil
+	}
+
+	constraints := []*semver.Constraints{}
+	for _, versionRange := range versionRanges {
+		c, err := semver.NewConstraint(versionRange)
+		if err == nil {
+			constraints = append(constraints, c)
+		}
+	}
+
+	satisfiesAll := func(v *semver.Version) bool {
+		for _, c := range constraints {
+			if !c.Check(v) {
+				return false
+			}
+		}
+		return true
+	}
+
+	locked := (*semver.Version)(nil)
+	for _, lp := range installation.LocalPackages {
+		if lp.Name != name || lp.Git != "" {
+			continue
+		}
+		v, err := semver.NewVersion(lp.Version)
+		if err == nil && satisfiesAll(v) && (locked == nil || v.GreaterThan(locked)) {
+			locked = v
+		}
+	}
+	if locked != nil {
+		return locked.String(), nil
+	}
+
+	info, err := getPackageInfo(installation.NpmConfig, name)
+	if err != nil {
+		return "", err
+	}
+	availableVersions := info.availableVersions()
+
+	for _, v := range availableVersions {
+		if satisfiesAll(v) {
+			return v.String(), nil
+		}
+	}
+
+	highestRange := versionRanges[len(versionRanges)-1]
+	highest := (*semver.Version)(nil)
+	for _, versionRange := range versionRanges {
+		c, err := semver.NewConstraint(versionRange)
+		if err != nil {
+			continue
+		}
+		for _, v := range availableVersions {
+			if c.Check(v) {
+				if highest == nil || v.GreaterThan(highest) {
+					highest = v
+					highestRange = versionRange
+				}
+				break
+			}
+		}
+	}
+
+	installation.addWarning(
+		name,
+		strings.Join(versionRanges, " "),
+		"",
+		"peer",
+		errors.New("no version of "+name+" satisfies "+strings.Join(versionRanges, ", ")+", using "+highestRange),
+	)
+
+	return highestRange, nil
+}
+
+// peers are installed next to the root packages
+// so dependants and their dependencies share the same one.
+// a root package not satisfying a peer range is kept with a warning,
+// a missing peer requested with several ranges is picked by peerVersion
+func (installation *Installation) resolvePeers() {
+	attempted := map[string]bool{}
+
+	for {
+		missing := map[string]*missingPeer{}
+
+		installation.walkPackages(func(p *Package) {
+			for name, versionRange := range p.PeerDependencies {
+				if attempted[name] ||
+					slices.Contains(p.OptionalPeers, name) ||
+					installation.rootPackage(name) != nil {
+					continue
+				}
+
+				if missing[name] == nil {
+					missing[name] = &missingPeer{}
+				}
+				if !slices.Contains(missing[name].versionRanges, versionRange) {
+					missing[name].versionRanges = append(missing[name].versionRanges, versionRange)
+				}
+				missing[name].dependants = append(missing[name].dependants, p)
+			}
+		})
+
+		names := []string{}
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		peers := []*Package{}
+		for _, name := range names {
+			m := missing[name]
+			attempted[name] = true
+
+			sort.Strings(m.versionRanges)
+			versionStr, err := installation.peerVersion(name, m.versionRanges)
+			if err != nil {
+				installation.addError(name, strings.Join(m.versionRanges, " "), "", "resolving", err)
+				continue
+			}
+
+			peer := installation.NewPackageWithVersionStr(name, versionStr)
+			if peer.Version == nil {
+				continue
+			}
+			peer.Dependants = m.dependants
+			peers = append(peers, &peer)
+		}
+
+		if len(peers) == 0 {
+			break
+		}
+
+		wg := sync.WaitGroup{}
+		mutex := sync.Mutex{}
+		installation.Packages = append(installation.Packages, peers...)
+		installation.getDependencies(peers, &wg, &mutex)
+		wg.Wait()
+
+		installation.untanglePackages()
+	}
+
+	installation.walkPackages(func(p *Package) {
+		for name, versionRange := range p.PeerDependencies {
+			peer := installation.rootPackage(name)
+			if peer == nil {
+				continue
+			}
+
+			constraints, err := semver.NewConstraint(versionRange)
+			if err != nil || constraints.Check(peer.Version) {
+				continue
+			}
+
+			installation.addWarning(
+				name,
+				versionRange,
+				"",
+				"peer",
+				errors.New(p.Name+"@"+p.Version.String()+" requires "+name+"@"+versionRange+", found "+peer.Version.String()),
+			)
+		}
+	})
+}
@@ -0,0 +1,196 @@
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	fs "fullstackedorg/fullstacked/src/fs"
+	setup "fullstackedorg/fullstacked/src/setup"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var defaultRegistry = "https://registry.npmjs.org/"
+
+var npmrcFileName = ".npmrc"
+
+// subset of the .npmrc format
+//
+//	registry=https://registry.npmjs.org/
+//	@scope:registry=https://npm.example.com/
+//	//npm.example.com/:_authToken=${NPM_TOKEN}
+//	//npm.example.com/:_auth=base64(user:password)
+//	vendor=vendor
+type NpmConfig struct {
+	Registry   string
+	Scopes     map[string]string
+	AuthTokens map[string]string
+	Auths      map[string]string
+	Vendor     string
+}
+
+func newNpmConfig() *NpmConfig {
+	return &NpmConfig{
+		Registry:   defaultRegistry,
+		Scopes:     map[string]string{},
+		AuthTokens: map[string]string{},
+		Auths:      map[string]string{},
+	}
+}
+
+// the config directory .npmrc is read first,
+// the project .npmrc overrides it key by key
+func loadNpmConfig(directory string) *NpmConfig {
+	config := newNpmConfig()
+	config.Vendor = path.Join(directory, defaultVendorDirectory)
+
+	config.parse(path.Join(setup.Directories.Config, npmrcFileName))
+	if directory != "" {
+		config.parse(path.Join(directory, npmrcFileName))
+	}
+
+	return config
+}
+
+func (c *NpmConfig) parse(file string) {
+	exists, isFile := fs.Exists(file)
+	if !exists || !isFile {
+		return
+	}
+
+	data, err := fs.ReadFile(file)
+	if err != nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = expandEnv(strings.TrimSpace(key))
+		value = expandEnv(strings.Trim(strings.TrimSpace(value), `"'`))
+
+		switch {
+		case key == "registry":
+			c.Registry = withTrailingSlash(value)
+		case key == "vendor":
+			// relative to the .npmrc file
+			if !path.IsAbs(value) {
+				value = path.Join(path.Dir(file), value)
+			}
+			c.Vendor = value
+		case strings.HasPrefix(key, "@") && strings.HasSuffix(key, ":registry"):
+			c.Scopes[strings.TrimSuffix(key, ":registry")] = withTrailingSlash(value)
+		case strings.HasPrefix(key, "//") && strings.HasSuffix(key, ":_authToken"):
+			c.AuthTokens[strings.TrimSuffix(key, ":_authToken")] = value
+		case strings.HasPrefix(key, "//") && strings.HasSuffix(key, ":_auth"):
+			c.Auths[strings.TrimSuffix(key, ":_auth")] = value
+		}
+	}
+}
+
+var envVariable = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// only the ${VAR} form, like npm
+func expandEnv(value string) string {
+	return envVariable.ReplaceAllStringFunc(value, func(match string) string {
+		return os.Getenv(match[2 : len(match)-1])
+	})
+}
+
+func withTrailingSlash(u string) string {
+	if strings.HasSuffix(u, "/") {
+		return u
+	}
+	return u + "/"
+}
+
+// registry serving the package, by scope or the default one
+func (c *NpmConfig) registryFor(name string) string {
+	if strings.HasPrefix(name, "@") {
+		scope, _, _ := strings.Cut(name, "/")
+		if registry, ok := c.Scopes[scope]; ok {
+			return registry
+		}
+	}
+
+	return c.Registry
+}
+
+// some private registries only accept @scope%2fname
+func escapePackageName(name string) string {
+	return strings.Replace(name, "/", "%2f", 1)
+}
+
+func (c *NpmConfig) packageUrl(name string) string {
+	return c.registryFor(name) + escapePackageName(name)
+}
+
+func (c *NpmConfig) packageVersionUrl(name string, version string) string {
+	return c.registryFor(name) + escapePackageName(name) + "/" + version
+}
+
+// credentials are matched against the request url
+// without its scheme, the longest prefix wins
+// so tarballs hosted elsewhere never receive them
+func (c *NpmConfig) authorization(requestUrl string) string {
+	u, err := url.Parse(requestUrl)
+	if err != nil {
+		return ""
+	}
+	nerfed := "//" + u.Host + u.Path
+
+	authorization := ""
+	longest := -1
+	match := func(credentials map[string]string, kind string) {
+		for prefix, value := range credentials {
+			// an unset ${VAR} expands to nothing
+			if value == "" {
+				continue
+			}
+			if len(prefix) > longest && strings.HasPrefix(nerfed, withTrailingSlash(prefix)) {
+				longest = len(prefix)
+				authorization = kind + " " + value
+			}
+		}
+	}
+	match(c.Auths, "Basic")
+	match(c.AuthTokens, "Bearer")
+
+	return authorization
+}
+
+func (c *NpmConfig) get(requestUrl string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if authorization := c.authorization(requestUrl); authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("%s: %s", requestUrl, response.Status)
+	}
+
+	return response, nil
+}
@@ -0,0 +1,161 @@
+package packages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	setup "fullstackedorg/fullstacked/src/setup"
+)
+
+func setupRegistryTest(t *testing.T) string {
+	previous := setup.Directories
+	t.Cleanup(func() { setup.Directories = previous })
+
+	tmp := t.TempDir()
+	setup.SetupDirectories(tmp, filepath.Join(tmp, "config"), tmp, filepath.Join(tmp, "tmp"))
+	os.MkdirAll(setup.Directories.Config, 0755)
+
+	project := filepath.Join(tmp, "project")
+	os.MkdirAll(project, 0755)
+
+	return project
+}
+
+type registryRequest struct {
+	path          string
+	authorization string
+}
+
+// registry answering every request with an empty
+// document and recording what it received
+type testRegistry struct {
+	server   *httptest.Server
+	mutex    sync.Mutex
+	requests []registryRequest
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	registry := &testRegistry{}
+	registry.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registry.mutex.Lock()
+		registry.requests = append(registry.requests, registryRequest{
+			path:          r.URL.EscapedPath(),
+			authorization: r.Header.Get("Authorization"),
+		})
+		registry.mutex.Unlock()
+
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(registry.server.Close)
+
+	return registry
+}
+
+func (r *testRegistry) host() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+func writeNpmrc(t *testing.T, directory string, lines ...string) {
+	err := os.WriteFile(filepath.Join(directory, npmrcFileName), []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNpmConfigScopeRouting(t *testing.T) {
+	project := setupRegistryTest(t)
+	t.Setenv("NPM_TOKEN", "secret")
+
+	public := newTestRegistry(t)
+	private := newTestRegistry(t)
+
+	writeNpmrc(t, setup.Directories.Config,
+		"registry="+public.server.URL,
+		"@ourco:registry=http://nowhere.invalid/",
+	)
+	// the project overrides the scope registry
+	writeNpmrc(t, project,
+		"# private packages",
+		"@ourco:registry="+private.server.URL+"/npm",
+		"//"+private.host()+"/npm/:_authToken=${NPM_TOKEN}",
+	)
+
+	config := loadNpmConfig(project)
+
+	urls := []string{
+		config.packageUrl("@ourco/a"),
+		config.packageVersionUrl("@ourco/a", "1.0.0"),
+		config.packageUrl("b"),
+		config.packageVersionUrl("@other/c", "2.0.0"),
+	}
+	for _, u := range urls {
+		response, err := config.get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+	}
+
+	tests := []struct {
+		registry *testRegistry
+		expected []registryRequest
+	}{
+		{private, []registryRequest{
+			{"/npm/@ourco%2fa", "Bearer secret"},
+			{"/npm/@ourco%2fa/1.0.0", "Bearer secret"},
+		}},
+		{public, []registryRequest{
+			{"/b", ""},
+			{"/@other%2fc/2.0.0", ""},
+		}},
+	}
+	for _, test := range tests {
+		if len(test.registry.requests) != len(test.expected) {
+			t.Fatalf("expected %v, got %v", test.expected, test.registry.requests)
+		}
+		for i, request := range test.registry.requests {
+			if request != test.expected[i] {
+				t.Errorf("expected %v, got %v", test.expected[i], request)
+			}
+		}
+	}
+}
+
+func TestNpmConfigAuthorization(t *testing.T) {
+	project := setupRegistryTest(t)
+	t.Setenv("NPM_TOKEN", "secret")
+
+	writeNpmrc(t, project,
+		"//registry.example.com/:_authToken=${NPM_TOKEN}",
+		"//registry.example.com/private/:_authToken=\"private\"",
+		"//other.example.com/:_auth=dXNlcjpwYXNz",
+		"//unset.example.com/:_authToken=${NPM_UNSET_TOKEN}",
+	)
+
+	config := loadNpmConfig(project)
+
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"https://registry.example.com/pkg", "Bearer secret"},
+		{"http://registry.example.com/pkg", "Bearer secret"},
+		{"https://registry.example.com/private/pkg", "Bearer private"},
+		{"https://registry.example.com/privateer/pkg", "Bearer secret"},
+		{"https://other.example.com/pkg/-/pkg-1.0.0.tgz", "Basic dXNlcjpwYXNz"},
+		{"https://unset.example.com/pkg", ""},
+		{"https://cdn.example.com/pkg/-/pkg-1.0.0.tgz", ""},
+		{"https://registry.example.com.evil.com/pkg", ""},
+	}
+	for _, test := range tests {
+		authorization := config.authorization(test.url)
+		if authorization != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.url, test.expected, authorization)
+		}
+	}
+}
@@ -1,21 +1,26 @@
 import { bridge } from "../bridge";
-import { serializeArgs } from "../bridge/serialization";
+import {
+    getLowestKeyIdAvailable,
+    serializeArgs
+} from "../bridge/serialization";
 import core_message from "../core_message";
 import { Project } from "../../editor/types";
 
 const pullPromises = new Map<
     string,
-    ((pullResponse: PullResponse) => void)[]
+    ((pullResponse: PullResponse, conflicts: string[], stash: string) => void)[]
 >();
 let addedListener = false;
 function setListenerOnce() {
     if (addedListener) return;
 
     core_message.addListener("git-pull", (message) => {
-        const { url, data, finished } = JSON.parse(message);
+        const { url, data, finished, conflicts, stash } = JSON.parse(message);
         if (!finished) return;
         const promises = pullPromises.get(url);
-        promises?.forEach((resolve) => resolve(data));
+        promises?.forEach((resolve) =>
+            resolve(data, conflicts || [], stash || "")
+        );
         pullPromises.delete(url);
     });
     addedListener = true;
@@ -27,9 +32,34 @@ export function gitAuthResponse(id: number, success: boolean) {
     bridge(payload);
 }
 
+// "git-clone" core messages report the
+// object counts sent by the remote in `objects`
+export type GitObjectsCount = {
+    phase: string;
+    current: number;
+    total: number;
+};
+
+export type CloneOptions = {
+    // 0 clones the full history
+    depth?: number;
+    singleBranch?: boolean;
+    // branch or tag
+    ref?: string;
+};
+
 // 70
-export function clone(url: string, into: string) {
-    const payload = new Uint8Array([70, ...serializeArgs([into, url])]);
+export function clone(url: string, into: string, options?: CloneOptions) {
+    const payload = new Uint8Array([
+        70,
+        ...serializeArgs([
+            into,
+            url,
+            options?.depth || 0,
+            !!options?.singleBranch,
+            options?.ref || ""
+        ])
+    ]);
     return bridge(payload);
 }
 
@@ -46,44 +76,78 @@ export function head(
     return bridge(payload, transformer);
 }
 
-export type Status = {
+export type Changes = {
     added: string[];
     deleted: string[];
     modified: string[];
 };
 
-// 72
-export function status(projectId: string): Promise<Status> {
-    const payload = new Uint8Array([72, ...serializeArgs([projectId])]);
+export type Status = Changes & {
+    staged: Changes;
+    unstaged: Changes;
+    untracked: string[];
+};
 
-    // added: 0, deleted: 1, modified: 2
-    const transformer = (s: (string | number)[]) => {
-        const status: Status = {
-            added: [],
-            deleted: [],
-            modified: []
-        };
+// added: 0, deleted: 1, modified: 2, unmodified: 3, untracked: 4
+function pushChange(changes: Changes, file: string, type: number) {
+    switch (type) {
+        case 0:
+            changes.added.push(file);
+            break;
+        case 1:
+            changes.deleted.push(file);
+            break;
+        case 2:
+            changes.modified.push(file);
+            break;
+    }
+}
 
-        for (let i = 0; i < s.length; i = i + 2) {
-            const file = s[i] as string;
-            const type = s[i + 1] as number;
-
-            switch (type) {
-                case 0:
-                    status.added.push(file);
-                    break;
-                case 1:
-                    status.deleted.push(file);
-                    break;
-                case 2:
-                    status.modified.push(file);
-                    break;
-            }
+function emptyStatus(): Status {
+    return {
+        added: [],
+        deleted: [],
+        modified: [],
+        staged: { added: [], deleted: [], modified: [] },
+        unstaged: { added: [], deleted: [], modified: [] },
+        untracked: []
+    };
+}
+
+// [file, staging, worktree, file, staging, worktree, ...]
+function addChanges(status: Status, s: (string | number)[]) {
+    for (let i = 0; i < s.length; i = i + 3) {
+        const file = s[i] as string;
+        const staging = s[i + 1] as number;
+        const worktree = s[i + 2] as number;
+
+        if (staging === 4) {
+            status.untracked.push(file);
+            status.added.push(file);
+            continue;
         }
 
-        return status;
-    };
+        pushChange(status.staged, file, staging);
+        pushChange(status.unstaged, file, worktree);
+
+        // overall change compared to HEAD
+        if (staging === 0 || staging === 1) {
+            pushChange(status, file, staging);
+        } else if (worktree === 1) {
+            pushChange(status, file, worktree);
+        } else if (staging === 2 || worktree === 2) {
+            pushChange(status, file, 2);
+        }
+    }
+
+    return status;
+}
 
+// 72
+export function status(projectId: string): Promise<Status> {
+    const payload = new Uint8Array([72, ...serializeArgs([projectId])]);
+    const transformer = (s: (string | number)[]) =>
+        addChanges(emptyStatus(), s);
     return bridge(payload, transformer);
 }
 
@@ -93,16 +157,38 @@ export enum PullResponse {
     UP_TO_DATE = "already up-to-date",
     REF_NOT_FOUND = "reference not found",
     UNAUTHORIZED = "authentication required",
-    UNREACHABLE = "unreacheable"
+    UNREACHABLE = "unreacheable",
+    MERGE_CONFLICTS = "merge conflicts",
+    MERGE_IN_PROGRESS = "merge in progress",
+    AUTOSTASH_CONFLICTS = "autostash conflicts"
 }
-export async function pull(project?: Project): Promise<PullResponse> {
+// empty remote pulls from the upstream of the current branch,
+// onStash receives the stash entry keeping the local changes
+// when the pull ends with conflicts
+export async function pull(
+    project?: Project,
+    onConflicts?: (files: string[]) => void,
+    autoStash = false,
+    remote = "",
+    onStash?: (stash: string) => void
+): Promise<PullResponse> {
     setListenerOnce();
 
-    const args = project ? serializeArgs([project.id]) : [];
+    // author is used for merge commits when the branches diverged
+    // and for the stash of local changes when autoStash
+    const args = project
+        ? serializeArgs([
+              project.id,
+              project.gitRepository?.name || "",
+              project.gitRepository?.email || "",
+              autoStash,
+              remote
+          ])
+        : [];
 
     const payload = new Uint8Array([73, ...args]);
 
-    const url = await remoteUrl(project);
+    const url = await remoteUrl(project, remote);
     let p = pullPromises.get(url);
     if (!p) {
         p = [];
@@ -110,7 +196,15 @@ export async function pull(project?: Project): Promise<PullResponse> {
     }
 
     return new Promise((resolve) => {
-        p.push(resolve);
+        p.push((pullResponse, conflicts, stash) => {
+            if (conflicts.length) {
+                onConflicts?.(conflicts);
+            }
+            if (stash) {
+                onStash?.(stash);
+            }
+            resolve(pullResponse);
+        });
         bridge(payload);
     });
 }
@@ -140,20 +234,28 @@ export function checkout(
 }
 
 // 76
-export function fetch(project: Project): Promise<void> {
-    const payload = new Uint8Array([76, ...serializeArgs([project.id])]);
+export function fetch(project: Project, remote = ""): Promise<void> {
+    const payload = new Uint8Array([
+        76,
+        ...serializeArgs([project.id, remote])
+    ]);
     return bridge(payload);
 }
 
 // 77
-export function commit(project: Project, commitMessage: string): Promise<void> {
+export function commit(
+    project: Project,
+    commitMessage: string,
+    stagedOnly = false
+): Promise<void> {
     const payload = new Uint8Array([
         77,
         ...serializeArgs([
             project.id,
             commitMessage,
             project.gitRepository.name || "",
-            project.gitRepository.email || ""
+            project.gitRepository.email || "",
+            stagedOnly
         ])
     ]);
 
@@ -164,21 +266,30 @@ type Branch = {
     name: string;
     remote: boolean;
     local: boolean;
+    // <remote>/<branch>, empty when not tracking
+    upstream: string;
 };
 
 // 78
-export async function branches(project: Project): Promise<Branch[]> {
-    const payload = new Uint8Array([78, ...serializeArgs([project.id])]);
+export async function branches(
+    project: Project,
+    remote = ""
+): Promise<Branch[]> {
+    const payload = new Uint8Array([
+        78,
+        ...serializeArgs([project.id, remote])
+    ]);
 
-    // [name, isLocal, isRemote, name, isLocal, isRemote, ...]
+    // [name, isRemote, isLocal, upstream, name, isRemote, isLocal, upstream, ...]
     const transformer = (branchesArgs: (string | boolean)[]) => {
         const branches: Branch[] = [];
 
-        for (let i = 0; i < branchesArgs.length; i = i + 3) {
+        for (let i = 0; i < branchesArgs.length; i = i + 4) {
             branches.push({
                 name: branchesArgs[i] as string,
                 remote: branchesArgs[i + 1] as boolean,
-                local: branchesArgs[i + 2] as boolean
+                local: branchesArgs[i + 2] as boolean,
+                upstream: branchesArgs[i + 3] as string
             });
         }
 
@@ -189,8 +300,11 @@ export async function branches(project: Project): Promise<Branch[]> {
 }
 
 // 79
-export function push(project: Project) {
-    const payload = new Uint8Array([79, ...serializeArgs([project.id])]);
+export function push(project: Project, tags = false, remote = "") {
+    const payload = new Uint8Array([
+        79,
+        ...serializeArgs([project.id, tags, remote])
+    ]);
     return bridge(payload);
 }
 
@@ -214,10 +328,960 @@ export function hasGit(project?: Project) {
 }
 
 // 83
-export function remoteUrl(project?: Project) {
-    const args = project ? [project.id] : [];
+export function remoteUrl(project?: Project, remote = "") {
+    const args = project ? [project.id, remote] : [];
 
     const payload = new Uint8Array([83, ...serializeArgs(args)]);
 
     return bridge(payload, ([url]) => url);
 }
+
+export type Signature = {
+    name: string;
+    email: string;
+    when: number;
+};
+
+export enum SignatureStatus {
+    NONE = "",
+    GOOD = "good",
+    // signed with a key that is not trusted
+    UNKNOWN = "unknown",
+    BAD = "bad"
+}
+
+export type Commit = {
+    hash: string;
+    parents: string[];
+    author: Signature;
+    committer: Signature;
+    message: string;
+    signature: SignatureStatus;
+    // identity of the key that made a good signature
+    signer: string;
+};
+
+// 84
+export function log(
+    project: Project,
+    options?: {
+        ref?: string;
+        limit?: number;
+        offset?: number;
+        path?: string;
+    }
+): Promise<Commit[]> {
+    const payload = new Uint8Array([
+        84,
+        ...serializeArgs([
+            project.id,
+            options?.ref || "",
+            options?.limit || 0,
+            options?.offset || 0,
+            options?.path || ""
+        ])
+    ]);
+
+    // [hash, parents, authorName, authorEmail, authorWhen, committerName, committerEmail, committerWhen, message, signature, signer, ...]
+    const transformer = (logArgs: (string | number)[]) => {
+        const commits: Commit[] = [];
+
+        for (let i = 0; i < logArgs.length; i = i + 11) {
+            const parents = logArgs[i + 1] as string;
+            commits.push({
+                hash: logArgs[i] as string,
+                parents: parents ? parents.split(" ") : [],
+                author: {
+                    name: logArgs[i + 2] as string,
+                    email: logArgs[i + 3] as string,
+                    when: logArgs[i + 4] as number
+                },
+                committer: {
+                    name: logArgs[i + 5] as string,
+                    email: logArgs[i + 6] as string,
+                    when: logArgs[i + 7] as number
+                },
+                message: logArgs[i + 8] as string,
+                signature: logArgs[i + 9] as SignatureStatus,
+                signer: logArgs[i + 10] as string
+            });
+        }
+
+        return commits;
+    };
+
+    return bridge(payload, transformer);
+}
+
+export type DiffLine = {
+    type: " " | "+" | "-";
+    oldLine: number;
+    newLine: number;
+    content: string;
+};
+
+export type DiffHunk = {
+    oldStart: number;
+    oldLines: number;
+    newStart: number;
+    newLines: number;
+    lines: DiffLine[];
+};
+
+export type FileDiff = {
+    from: string;
+    to: string;
+    binary: boolean;
+    hunks: DiffHunk[];
+    patch: string;
+};
+
+// [from, to, binary, patch, hunkCount,
+//     oldStart, oldLines, newStart, newLines, lineCount,
+//         type, oldLine, newLine, content, ...
+//     ...
+// ...]
+const diffTransformer = (args: any[]) => {
+    if (args.length === 1) {
+        throw new Error(args[0]);
+    }
+
+    const diffs: FileDiff[] = [];
+    let i = 0;
+    while (i < args.length) {
+        const fileDiff: FileDiff = {
+            from: args[i],
+            to: args[i + 1],
+            binary: args[i + 2],
+            patch: args[i + 3],
+            hunks: []
+        };
+        const hunkCount = args[i + 4] as number;
+        i = i + 5;
+
+        for (let h = 0; h < hunkCount; h++) {
+            const hunk: DiffHunk = {
+                oldStart: args[i],
+                oldLines: args[i + 1],
+                newStart: args[i + 2],
+                newLines: args[i + 3],
+                lines: []
+            };
+            const lineCount = args[i + 4] as number;
+            i = i + 5;
+
+            for (let l = 0; l < lineCount; l++) {
+                hunk.lines.push({
+                    type: args[i],
+                    oldLine: args[i + 1],
+                    newLine: args[i + 2],
+                    content: args[i + 3]
+                });
+                i = i + 4;
+            }
+
+            fileDiff.hunks.push(hunk);
+        }
+
+        diffs.push(fileDiff);
+    }
+
+    return diffs;
+};
+
+// 85
+export function diff(
+    project: Project,
+    staged = false,
+    files: string[] = []
+): Promise<FileDiff[]> {
+    const payload = new Uint8Array([
+        85,
+        ...serializeArgs([project.id, staged, ...files])
+    ]);
+
+    return bridge(payload, diffTransformer);
+}
+
+// 86
+export function diffCommits(
+    project: Project,
+    from: string,
+    to: string,
+    files: string[] = []
+): Promise<FileDiff[]> {
+    const payload = new Uint8Array([
+        86,
+        ...serializeArgs([project.id, from, to, ...files])
+    ]);
+
+    return bridge(payload, diffTransformer);
+}
+
+// 87
+export function add(project: Project, files: string[]): Promise<void> {
+    const payload = new Uint8Array([
+        87,
+        ...serializeArgs([project.id, ...files])
+    ]);
+
+    return bridge(payload);
+}
+
+// 88
+export function unstage(project: Project, files: string[]): Promise<void> {
+    const payload = new Uint8Array([
+        88,
+        ...serializeArgs([project.id, ...files])
+    ]);
+
+    return bridge(payload);
+}
+
+// 89
+export function mergeAbort(project: Project): Promise<void> {
+    const payload = new Uint8Array([89, ...serializeArgs([project.id])]);
+    return bridge(payload);
+}
+
+// 90
+export function mergeContinue(project: Project): Promise<void> {
+    const payload = new Uint8Array([
+        90,
+        ...serializeArgs([
+            project.id,
+            project.gitRepository.name || "",
+            project.gitRepository.email || ""
+        ])
+    ]);
+
+    return bridge(payload);
+}
+
+// 91
+export function stashPush(project: Project, message = ""): Promise<void> {
+    const payload = new Uint8Array([
+        91,
+        ...serializeArgs([
+            project.id,
+            message,
+            project.gitRepository?.name || "",
+            project.gitRepository?.email || ""
+        ])
+    ]);
+
+    return bridge(payload);
+}
+
+export type StashEntry = {
+    index: number;
+    hash: string;
+    message: string;
+    date: Date;
+};
+
+// 92
+export function stashList(project: Project): Promise<StashEntry[]> {
+    const payload = new Uint8Array([92, ...serializeArgs([project.id])]);
+
+    const transformer = (args: any[]) => {
+        if (args.length % 3 !== 0) {
+            throw new Error(args.join(""));
+        }
+
+        const entries: StashEntry[] = [];
+        for (let i = 0; i < args.length; i = i + 3) {
+            entries.push({
+                index: i / 3,
+                hash: args[i],
+                message: args[i + 1],
+                date: new Date(args[i + 2])
+            });
+        }
+        return entries;
+    };
+
+    return bridge(payload, transformer);
+}
+
+// 93
+// resolves with the conflicted files
+export function stashApply(
+    project: Project,
+    index = 0,
+    pop = false
+): Promise<string[]> {
+    const payload = new Uint8Array([
+        93,
+        ...serializeArgs([project.id, index, pop])
+    ]);
+
+    return bridge(payload, (conflicts: string[]) => conflicts);
+}
+
+// 94
+export function stashDrop(project: Project, index = 0): Promise<void> {
+    const payload = new Uint8Array([94, ...serializeArgs([project.id, index])]);
+    return bridge(payload);
+}
+
+export type Tag = {
+    name: string;
+    hash: string;
+    annotated: boolean;
+    message: string;
+};
+
+// 95
+export function tags(project: Project): Promise<Tag[]> {
+    const payload = new Uint8Array([95, ...serializeArgs([project.id])]);
+
+    // [name, hash, annotated, message, name, hash, annotated, message, ...]
+    const transformer = (tagsArgs: (string | boolean)[]) => {
+        const tags: Tag[] = [];
+
+        for (let i = 0; i < tagsArgs.length; i = i + 4) {
+            tags.push({
+                name: tagsArgs[i] as string,
+                hash: tagsArgs[i + 1] as string,
+                annotated: tagsArgs[i + 2] as boolean,
+                message: tagsArgs[i + 3] as string
+            });
+        }
+
+        return tags;
+    };
+
+    return bridge(payload, transformer);
+}
+
+// 96
+// a message makes an annotated tag
+export function tagCreate(
+    project: Project,
+    name: string,
+    ref = "",
+    message = ""
+): Promise<void> {
+    const payload = new Uint8Array([
+        96,
+        ...serializeArgs([
+            project.id,
+            name,
+            ref,
+            message,
+            project.gitRepository?.name || "",
+            project.gitRepository?.email || ""
+        ])
+    ]);
+
+    return bridge(payload);
+}
+
+// 97
+export function tagDelete(project: Project, name: string): Promise<void> {
+    const payload = new Uint8Array([97, ...serializeArgs([project.id, name])]);
+    return bridge(payload);
+}
+
+export type Remote = {
+    name: string;
+    url: string;
+};
+
+// 110
+export function remotes(project: Project): Promise<Remote[]> {
+    const payload = new Uint8Array([110, ...serializeArgs([project.id])]);
+
+    // [name, url, name, url, ...]
+    const transformer = (remotesArgs: string[]) => {
+        const remotes: Remote[] = [];
+
+        for (let i = 0; i < remotesArgs.length; i = i + 2) {
+            remotes.push({
+                name: remotesArgs[i],
+                url: remotesArgs[i + 1]
+            });
+        }
+
+        return remotes;
+    };
+
+    return bridge(payload, transformer);
+}
+
+// 111
+export function remoteAdd(
+    project: Project,
+    name: string,
+    url: string
+): Promise<void> {
+    const payload = new Uint8Array([
+        111,
+        ...serializeArgs([project.id, name, url])
+    ]);
+
+    return bridge(payload);
+}
+
+// 112
+export function remoteRemove(project: Project, name: string): Promise<void> {
+    const payload = new Uint8Array([112, ...serializeArgs([project.id, name])]);
+    return bridge(payload);
+}
+
+// 113
+export function remoteRename(
+    project: Project,
+    name: string,
+    newName: string
+): Promise<void> {
+    const payload = new Uint8Array([
+        113,
+        ...serializeArgs([project.id, name, newName])
+    ]);
+
+    return bridge(payload);
+}
+
+// 114
+// empty remote removes the upstream
+export function setUpstream(
+    project: Project,
+    branch: string,
+    remote: string,
+    remoteBranch = ""
+): Promise<void> {
+    const payload = new Uint8Array([
+        114,
+        ...serializeArgs([project.id, branch, remote, remoteBranch])
+    ]);
+
+    return bridge(payload);
+}
+
+// 115
+// replaces any existing key, resolves with the public key
+export function sshKeyGenerate(comment = ""): Promise<string> {
+    const payload = new Uint8Array([115, ...serializeArgs([comment])]);
+    return bridge(payload, ([publicKey]) => publicKey);
+}
+
+// 116
+// empty string when no key was generated
+export function sshPublicKey(): Promise<string> {
+    const payload = new Uint8Array([116]);
+    return bridge(payload, ([publicKey]) => publicKey);
+}
+
+// 117
+// answer to a "git-host-key" core message
+export function hostKeyResponse(id: string, trusted: boolean) {
+    const payload = new Uint8Array([117, ...serializeArgs([id, trusted])]);
+    bridge(payload);
+}
+
+export type Submodule = {
+    path: string;
+    url: string;
+    // commit recorded in the project
+    expected: string;
+    // commit checked out, empty when not initialized
+    current: string;
+    // no changes in the submodule
+    clean: boolean;
+};
+
+// 118
+export function submodules(project: Project): Promise<Submodule[]> {
+    const payload = new Uint8Array([118, ...serializeArgs([project.id])]);
+
+    // [path, url, expected, current, clean, ...]
+    const transformer = (submodulesArgs: (string | boolean)[]) => {
+        const submodules: Submodule[] = [];
+
+        for (let i = 0; i < submodulesArgs.length; i = i + 5) {
+            submodules.push({
+                path: submodulesArgs[i] as string,
+                url: submodulesArgs[i + 1] as string,
+                expected: submodulesArgs[i + 2] as string,
+                current: submodulesArgs[i + 3] as string,
+                clean: submodulesArgs[i + 4] as boolean
+            });
+        }
+
+        return submodules;
+    };
+
+    return bridge(payload, transformer);
+}
+
+// 119
+// init and checkout the recorded commit of every submodule, recursively
+export function submodulesUpdate(project: Project): Promise<void> {
+    const payload = new Uint8Array([119, ...serializeArgs([project.id])]);
+    return bridge(payload);
+}
+
+// commits and annotated tags are signed
+// once a key is generated or imported,
+// they fail while a passphrase protected key is locked
+
+// 120
+// replaces the signing key, resolves with the armored public key.
+// with a passphrase, the key is stored encrypted
+// and unlocked until the app is closed
+export function gpgKeyGenerate(
+    name: string,
+    email: string,
+    passphrase = ""
+): Promise<string> {
+    const payload = new Uint8Array([
+        120,
+        ...serializeArgs([name, email, passphrase])
+    ]);
+    return bridge(payload, ([publicKey]) => publicKey);
+}
+
+// 121
+// armored private key, the passphrase is required for an encrypted key
+// and encrypts a clear one
+export function gpgKeyImport(
+    privateKey: string,
+    passphrase = ""
+): Promise<void> {
+    const payload = new Uint8Array([
+        121,
+        ...serializeArgs([privateKey, passphrase])
+    ]);
+    return bridge(payload);
+}
+
+// 122
+// stops signing, trusted keys are kept
+export function gpgKeyDelete(): Promise<void> {
+    const payload = new Uint8Array([122]);
+    return bridge(payload);
+}
+
+// 123
+// empty string when not signing,
+// a locked key still gives its public key
+export function gpgPublicKey(): Promise<string> {
+    const payload = new Uint8Array([123]);
+    return bridge(payload, ([publicKey]) => publicKey);
+}
+
+// 124
+// armored public keys to verify signatures with
+export function gpgTrustKey(publicKey: string): Promise<void> {
+    const payload = new Uint8Array([124, ...serializeArgs([publicKey])]);
+    return bridge(payload);
+}
+
+// 134
+// a passphrase protected key signs once unlocked
+export function gpgKeyUnlock(passphrase: string): Promise<void> {
+    const payload = new Uint8Array([134, ...serializeArgs([passphrase])]);
+    return bridge(payload);
+}
+
+// credentials are stored encrypted and matched
+// to the url with the most specific path
+
+export type Credential = {
+    host: string;
+    // scopes the credential, e.g.: org for https://host/org/repo.git
+    path: string;
+    username: string;
+    // sent as bearer token instead of username/password
+    hasToken: boolean;
+    // unix ms, 0 never expires
+    expires: number;
+};
+
+// 125
+// secrets are never sent back
+export function credentials(): Promise<Credential[]> {
+    const payload = new Uint8Array([125]);
+
+    const transformer = (credentialsArgs: (string | boolean | number)[]) => {
+        const credentials: Credential[] = [];
+
+        for (let i = 0; i < credentialsArgs.length; i = i + 5) {
+            credentials.push({
+                host: credentialsArgs[i] as string,
+                path: credentialsArgs[i + 1] as string,
+                username: credentialsArgs[i + 2] as string,
+                hasToken: credentialsArgs[i + 3] as boolean,
+                expires: credentialsArgs[i + 4] as number
+            });
+        }
+
+        return credentials;
+    };
+
+    return bridge(payload, transformer);
+}
+
+export type CredentialSecrets = {
+    username?: string;
+    password?: string;
+    token?: string;
+};
+
+// 126
+// replaces the credential with the same host and path
+export function credentialSave(
+    host: string,
+    path: string,
+    secrets: CredentialSecrets,
+    expires = 0
+): Promise<void> {
+    const payload = new Uint8Array([
+        126,
+        ...serializeArgs([
+            host,
+            path,
+            secrets.username || "",
+            secrets.password || "",
+            secrets.token || "",
+            expires
+        ])
+    ]);
+    return bridge(payload);
+}
+
+// 127
+export function credentialDelete(host: string, path = ""): Promise<void> {
+    const payload = new Uint8Array([127, ...serializeArgs([host, path])]);
+    return bridge(payload);
+}
+
+// 140
+// removes all credentials, required when their key is lost
+export function credentialsReset(): Promise<void> {
+    const payload = new Uint8Array([140]);
+    return bridge(payload);
+}
+
+// cherry-pick, rebase and revert stop on conflicts
+// until continued or aborted
+
+export enum SequencerResponse {
+    DONE = "",
+    UP_TO_DATE = "up-to-date",
+    CONFLICTS = "conflicts"
+}
+
+const sequencerPromises: {
+    resolve: (response: SequencerResponse, conflicts: string[]) => void;
+    reject: (error: Error) => void;
+}[] = [];
+let addedSequencerListener = false;
+function setSequencerListenerOnce() {
+    if (addedSequencerListener) return;
+
+    const listener = (message: string) => {
+        const { data, error, finished, conflicts } = JSON.parse(message);
+        if (!finished) return;
+        sequencerPromises
+            .splice(0, sequencerPromises.length)
+            .forEach(({ resolve, reject }) =>
+                error ? reject(new Error(data)) : resolve(data, conflicts || [])
+            );
+    };
+    core_message.addListener("git-cherry-pick", listener);
+    core_message.addListener("git-rebase", listener);
+    core_message.addListener("git-revert", listener);
+    addedSequencerListener = true;
+}
+
+function sequencer(
+    payload: Uint8Array,
+    onConflicts?: (files: string[]) => void
+): Promise<SequencerResponse> {
+    setSequencerListenerOnce();
+
+    return new Promise((resolve, reject) => {
+        sequencerPromises.push({
+            resolve: (response, conflicts) => {
+                if (conflicts.length) {
+                    onConflicts?.(conflicts);
+                }
+                resolve(response);
+            },
+            reject
+        });
+        bridge(payload);
+    });
+}
+
+// 128
+// commits keep their author, the project user is the committer
+export function cherryPick(
+    project: Project,
+    commits: string[],
+    onConflicts?: (files: string[]) => void
+): Promise<SequencerResponse> {
+    const payload = new Uint8Array([
+        128,
+        ...serializeArgs([
+            project.id,
+            project.gitRepository?.name || "",
+            project.gitRepository?.email || "",
+            ...commits
+        ])
+    ]);
+    return sequencer(payload, onConflicts);
+}
+
+// 129
+// replays the commits of the current branch on top of onto
+export function rebase(
+    project: Project,
+    onto: string,
+    onConflicts?: (files: string[]) => void
+): Promise<SequencerResponse> {
+    const payload = new Uint8Array([
+        129,
+        ...serializeArgs([
+            project.id,
+            onto,
+            project.gitRepository?.name || "",
+            project.gitRepository?.email || ""
+        ])
+    ]);
+    return sequencer(payload, onConflicts);
+}
+
+// 130
+// rejects while conflict markers are left
+export function sequencerContinue(
+    project: Project,
+    onConflicts?: (files: string[]) => void
+): Promise<SequencerResponse> {
+    const payload = new Uint8Array([
+        130,
+        ...serializeArgs([
+            project.id,
+            project.gitRepository?.name || "",
+            project.gitRepository?.email || ""
+        ])
+    ]);
+    return sequencer(payload, onConflicts);
+}
+
+// 131
+export function sequencerAbort(project: Project): Promise<void> {
+    const payload = new Uint8Array([131, ...serializeArgs([project.id])]);
+    return bridge(payload);
+}
+
+export enum ResetMode {
+    // moves HEAD only
+    SOFT = "soft",
+    // also resets the index
+    MIXED = "mixed",
+    // also resets the worktree, untracked files are kept
+    HARD = "hard"
+}
+
+// 132
+// moves the current branch to ref, a hard reset
+// ends a merge, a soft one is refused during a merge
+export function reset(
+    project: Project,
+    ref: string,
+    mode = ResetMode.MIXED
+): Promise<void> {
+    const payload = new Uint8Array([
+        132,
+        ...serializeArgs([project.id, ref, mode])
+    ]);
+    return bridge(payload);
+}
+
+// 133
+// commits the inverse of the commit changes
+export function revert(
+    project: Project,
+    commit: string,
+    onConflicts?: (files: string[]) => void
+): Promise<SequencerResponse> {
+    const payload = new Uint8Array([
+        133,
+        ...serializeArgs([
+            project.id,
+            commit,
+            project.gitRepository?.name || "",
+            project.gitRepository?.email || ""
+        ])
+    ]);
+    return sequencer(payload, onConflicts);
+}
+
+export type BlameLine = {
+    // commit that last modified the line
+    hash: string;
+    authorName: string;
+    authorEmail: string;
+    // unix ms
+    date: number;
+    text: string;
+};
+
+// 98
+// empty ref blames HEAD
+export function blame(
+    project: Project,
+    file: string,
+    ref = ""
+): Promise<BlameLine[]> {
+    const payload = new Uint8Array([
+        98,
+        ...serializeArgs([project.id, file, ref])
+    ]);
+
+    const transformer = (blameArgs: (string | number)[]) => {
+        const lines: BlameLine[] = [];
+
+        for (let i = 0; i < blameArgs.length; i = i + 5) {
+            lines.push({
+                hash: blameArgs[i] as string,
+                authorName: blameArgs[i + 1] as string,
+                authorEmail: blameArgs[i + 2] as string,
+                date: blameArgs[i + 3] as number,
+                text: blameArgs[i + 4] as string
+            });
+        }
+
+        return lines;
+    };
+
+    return bridge(payload, transformer);
+}
+
+// serves the projects over git smart HTTP so other devices
+// can clone and push: http://<address>:<port>/<project id>
+// pushing to the checked out branch updates its worktree
+// only when it has no changes
+
+export type GitServer = {
+    port: number;
+    // basic auth password, any username is accepted
+    password: string;
+    readOnly: boolean;
+    // reachable from the local network, over plain HTTP
+    exposed: boolean;
+    // LAN addresses of this device when exposed, else loopback
+    addresses: string[];
+};
+
+function gitServerTransformer(serverArgs: (string | number | boolean)[]) {
+    if (!serverArgs.length) return null;
+
+    const [port, password, readOnly, exposed, ...addresses] = serverArgs;
+    return {
+        port,
+        password,
+        readOnly,
+        exposed,
+        addresses
+    } as GitServer;
+}
+
+// 135
+// port 0 picks a free port, only this device can connect
+// unless exposed to the local network
+export function serverStart(
+    port = 0,
+    readOnly = false,
+    expose = false
+): Promise<GitServer> {
+    const payload = new Uint8Array([
+        135,
+        ...serializeArgs([port, readOnly, expose])
+    ]);
+    return bridge(payload, gitServerTransformer);
+}
+
+// 136
+// null when not running
+export function serverStatus(): Promise<GitServer | null> {
+    const payload = new Uint8Array([136]);
+    return bridge(payload, gitServerTransformer);
+}
+
+// 137
+export function serverStop(): Promise<void> {
+    const payload = new Uint8Array([137]);
+    return bridge(payload);
+}
+
+// 138
+// downloads the missing Git LFS objects and replaces
+// the pointer files in the worktree, which clone, pull
+// and checkout already do
+export function lfsPull(project: Project): Promise<void> {
+    const payload = new Uint8Array([138, ...serializeArgs([project.id])]);
+    return bridge(payload);
+}
+
+const activeStatuses = new Map<
+    number,
+    {
+        status: Status;
+        onChanges?: (status: Status) => void;
+        resolve: (status: Status) => void;
+        reject: (error: Error) => void;
+    }
+>();
+let addedStatusListener = false;
+function setStatusListenerOnce() {
+    if (addedStatusListener) return;
+
+    core_message.addListener("git-status", (message) => {
+        const { id, changes, finished, error } = JSON.parse(message);
+        const activeStatus = activeStatuses.get(id);
+        if (!activeStatus) return;
+
+        addChanges(activeStatus.status, changes || []);
+
+        if (!finished) {
+            activeStatus.onChanges?.(activeStatus.status);
+            return;
+        }
+
+        activeStatuses.delete(id);
+        if (error) {
+            activeStatus.reject(new Error(error));
+        } else {
+            activeStatus.resolve(activeStatus.status);
+        }
+    });
+    addedStatusListener = true;
+}
+
+// 139
+// same as status, onChanges receives the status
+// found so far while large worktrees are scanned
+export function statusStream(
+    projectId: string,
+    onChanges?: (status: Status) => void
+): Promise<Status> {
+    setStatusListenerOnce();
+
+    const id = getLowestKeyIdAvailable(activeStatuses);
+    const payload = new Uint8Array([139, ...serializeArgs([projectId, id])]);
+
+    return new Promise((resolve, reject) => {
+        activeStatuses.set(id, {
+            status: emptyStatus(),
+            onChanges,
+            resolve,
+            reject
+        });
+        bridge(payload);
+    });
+}
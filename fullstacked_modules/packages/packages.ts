@@ -9,16 +9,39 @@ import core_message from "../core_message";
 const activeInstallations = new Map<
     number,
     {
-        project: Project;
+        project?: Project;
         installing: Map<string, PackageInfoProgress>;
         progress?: InstallationProgressCb;
         resolve: (result: InstallationResult) => void;
+        retry: () => Promise<InstallationResult>;
     }
 >();
 
+export type InstallationError = {
+    name: string;
+    // requested range when resolving
+    version: string;
+    location?: string;
+    // optional and peer are warnings
+    stage: "resolving" | "cloning" | "installing" | "optional" | "peer";
+    message: string;
+};
+
+// failed installations resolve too, check the status
 type InstallationResult = {
     duration: number;
     packagesInstalledCount: number;
+    packagesRemovedCount: number;
+    status: "success" | "failure";
+    errors?: InstallationError[];
+    // skipped optional packages, peers conflicts
+    warnings?: InstallationError[];
+    // offline installation, packages found neither
+    // in the cache nor in the vendor directory
+    missing?: string[];
+    // runs the whole installation again with the same
+    // arguments, not only the failed packages
+    retry: () => Promise<InstallationResult>;
 };
 
 export type PackageInfoProgress = {
@@ -76,8 +99,11 @@ function installationsListener(messageStr: string) {
         id: number;
     } & InstallationResult;
 
-    activeInstallation.resolve(installation);
     activeInstallations.delete(message.id);
+
+    installation.retry = activeInstallation.retry;
+
+    activeInstallation.resolve(installation);
 }
 
 let addedListener = false;
@@ -95,20 +121,45 @@ export function install(
     packagesNames: string[],
     progress?: InstallationProgressCb,
     dev = false
+) {
+    return startInstallation(project, 60, [dev, ...packagesNames], progress);
+}
+
+//61
+// offline only installs from the packages cache
+// and the tarballs of the vendor directory
+export function installQuick(
+    project?: Project,
+    progress?: InstallationProgressCb,
+    offline = false
+) {
+    return startInstallation(project, 61, [offline], progress);
+}
+
+// no project for the current one
+function startInstallation(
+    project: Project | undefined,
+    method: number,
+    args: any[],
+    progress?: InstallationProgressCb
 ) {
     setListenerOnce();
 
     const installationId = getLowestKeyIdAvailable(activeInstallations);
 
-    let args: any[] = [project.id, installationId, dev, ...packagesNames];
+    const ids = project ? [project.id, installationId] : [installationId];
 
-    const payload = new Uint8Array([60, ...serializeArgs(args)]);
+    const payload = new Uint8Array([
+        method,
+        ...serializeArgs([...ids, ...args])
+    ]);
 
     return new Promise<InstallationResult>((resolve) => {
         activeInstallations.set(installationId, {
             project,
             progress,
             resolve,
+            retry: () => startInstallation(project, method, args, progress),
             installing: new Map()
         });
 
@@ -116,27 +167,45 @@ export function install(
     });
 }
 
-//61
-export function installQuick(
-    project?: Project,
+// 62
+// also removes the dependencies no other package needs
+export function uninstall(
+    project: Project,
+    packagesNames: string[],
     progress?: InstallationProgressCb
 ) {
-    setListenerOnce();
-
-    const installationId = getLowestKeyIdAvailable(activeInstallations);
+    return startInstallation(project, 62, packagesNames, progress);
+}
 
-    let args: any[] = project ? [project.id, installationId] : [installationId];
+// 63
+// no packages names updates all packages,
+// toLatest ignores the ranges of package.json
+export function update(
+    project: Project,
+    packagesNames: string[] = [],
+    toLatest = false,
+    progress?: InstallationProgressCb
+) {
+    return startInstallation(
+        project,
+        63,
+        [toLatest, ...packagesNames],
+        progress
+    );
+}
 
-    const payload = new Uint8Array([61, ...serializeArgs(args)]);
+// 64
+// removes the least recently used packages of the shared cache
+// until it is under maxSize bytes, defaults to 2GB
+export function pruneCache(
+    maxSize?: number
+): Promise<{ removed: number; size: number }> {
+    const args = typeof maxSize === "number" ? [maxSize] : [];
+    const payload = new Uint8Array([64, ...serializeArgs(args)]);
 
-    return new Promise<InstallationResult>((resolve) => {
-        activeInstallations.set(installationId, {
-            project,
-            progress,
-            resolve,
-            installing: new Map()
-        });
+    const transformer = ([removed, size]) => {
+        return { removed, size };
+    };
 
-        bridge(payload);
-    });
+    return bridge(payload, transformer);
 }